@@ -0,0 +1,94 @@
+// Package benchmark times the schedulers of package concurrent over a matrix
+// of modes, datasets and thread counts and reports their speedup over the
+// sequential version.
+package benchmark
+
+import (
+	"math"
+	"proj3/concurrent"
+	"time"
+)
+
+// Matrix lists the runs of a benchmark. Every dataset is also run
+// sequentially, once per repetition, as the reference for the speedups.
+type Matrix struct {
+	Modes    []string // parallel modes, e.g. "ws" and "mr"
+	Datasets []string // data directories, e.g. "small", "big", "mixture"
+	Threads  []int
+	Repeat   int
+
+	// Base is the configuration of every run; Mode, DataDirs and
+	// ThreadCount are set from the matrix.
+	Base concurrent.Config
+}
+
+// Result holds the times of one cell of the matrix.
+type Result struct {
+	Mode    string    `json:"mode"`
+	Dataset string    `json:"dataset"`
+	Threads int       `json:"threads"`
+	Seconds []float64 `json:"seconds"`
+	Mean    float64   `json:"mean"`
+	Stddev  float64   `json:"stddev"`
+	Speedup float64   `json:"speedup"` // sequential mean over Mean
+}
+
+// Run runs every cell of matrix in turn, the sequential runs of each dataset
+// first, and calls progress, if not nil, after each cell.
+func Run(matrix Matrix, progress func(Result)) []Result {
+	var results []Result
+	for _, dataset := range matrix.Datasets {
+		seq := measure(matrix, "s", dataset, 1)
+		seq.Speedup = 1
+		results = append(results, seq)
+		if progress != nil {
+			progress(seq)
+		}
+		for _, mode := range matrix.Modes {
+			for _, threads := range matrix.Threads {
+				result := measure(matrix, mode, dataset, threads)
+				if result.Mean > 0 {
+					result.Speedup = seq.Mean / result.Mean
+				}
+				results = append(results, result)
+				if progress != nil {
+					progress(result)
+				}
+			}
+		}
+	}
+	return results
+}
+
+func measure(matrix Matrix, mode, dataset string, threads int) Result {
+	config := matrix.Base
+	config.Mode = mode
+	config.DataDirs = dataset
+	config.ThreadCount = threads
+	result := Result{Mode: mode, Dataset: dataset, Threads: threads}
+	for i := 0; i < matrix.Repeat; i++ {
+		start := time.Now()
+		concurrent.Schedule(config)
+		result.Seconds = append(result.Seconds, time.Since(start).Seconds())
+	}
+	result.Mean, result.Stddev = meanStddev(result.Seconds)
+	return result
+}
+
+// meanStddev returns the mean and sample standard deviation of xs.
+func meanStddev(xs []float64) (mean, stddev float64) {
+	if len(xs) == 0 {
+		return 0, 0
+	}
+	for _, x := range xs {
+		mean += x
+	}
+	mean /= float64(len(xs))
+	if len(xs) < 2 {
+		return mean, 0
+	}
+	for _, x := range xs {
+		stddev += (x - mean) * (x - mean)
+	}
+	return mean, math.Sqrt(stddev / float64(len(xs)-1))
+}
@@ -0,0 +1,115 @@
+package benchmark
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"proj3/concurrent"
+	"strings"
+	"testing"
+)
+
+func TestMeanStddev(t *testing.T) {
+	mean, stddev := meanStddev([]float64{2, 4, 4, 4, 5, 5, 7, 9})
+	if mean != 5 || math.Abs(stddev-math.Sqrt(32.0/7)) > 1e-12 {
+		t.Errorf("got mean %v, stddev %v", mean, stddev)
+	}
+	if mean, stddev := meanStddev([]float64{3}); mean != 3 || stddev != 0 {
+		t.Errorf("one sample: got mean %v, stddev %v", mean, stddev)
+	}
+}
+
+// dataset writes a tiny data directory under root and returns the path of
+// its effects file.
+func dataset(t *testing.T, root string) string {
+	dir := filepath.Join(root, "in", "tiny")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	m := image.NewGray(image.Rect(0, 0, 20, 12))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 7)
+	}
+	f, err := os.Create(filepath.Join(dir, "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, m)
+	f.Close()
+	effects := filepath.Join(root, "effects.txt")
+	requests := `{"inPath": "a.png", "outPath": "a_b.png", "effects": ["B"]}
+{"inPath": "a.png", "outPath": "a_s.png", "effects": ["S", "G"]}
+`
+	if err := os.WriteFile(effects, []byte(requests), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return effects
+}
+
+func TestRunAndReport(t *testing.T) {
+	root := t.TempDir()
+	matrix := Matrix{
+		Modes:    []string{"ws", "mr"},
+		Datasets: []string{"tiny"},
+		Threads:  []int{1, 2},
+		Repeat:   3,
+		Base: concurrent.Config{
+			EffectsFiles: []string{dataset(t, root)},
+			InDir:        filepath.Join(root, "in"),
+			OutDir:       filepath.Join(root, "out"),
+		},
+	}
+	calls := 0
+	results := Run(matrix, func(Result) { calls++ })
+	if len(results) != 5 || calls != 5 {
+		t.Fatalf("got %d results and %d progress calls, want 5", len(results), calls)
+	}
+	if seq := results[0]; seq.Mode != "s" || seq.Threads != 1 || seq.Speedup != 1 {
+		t.Errorf("first result %+v is not the sequential reference", seq)
+	}
+	for _, result := range results {
+		if len(result.Seconds) != 3 || result.Mean <= 0 || result.Speedup <= 0 {
+			t.Errorf("bad result %+v", result)
+		}
+		if want := results[0].Mean / result.Mean; math.Abs(result.Speedup-want) > 1e-9 {
+			t.Errorf("%s %d threads: speedup %v, want %v", result.Mode, result.Threads, result.Speedup, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(rows) != 6 || rows[2][0] != "ws" || len(strings.Fields(rows[1][6])) != 3 {
+		t.Errorf("CSV %v, %v", rows, err)
+	}
+
+	buf.Reset()
+	if err := WriteSpeedupSVG(&buf, results, "ws"); err != nil {
+		t.Fatal(err)
+	}
+	decoder := xml.NewDecoder(&buf)
+	polylines := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err != io.EOF {
+				t.Errorf("SVG doesn't parse: %v", err)
+			}
+			break
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "polyline" {
+			polylines++
+		}
+	}
+	if polylines != 1 {
+		t.Errorf("SVG has %d lines, want one for the dataset", polylines)
+	}
+}
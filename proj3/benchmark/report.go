@@ -0,0 +1,124 @@
+package benchmark
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteCSV writes a row per result with its mean, standard deviation and
+// speedup followed by the time of every repetition.
+func WriteCSV(w io.Writer, results []Result) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"mode", "dataset", "threads", "mean", "stddev", "speedup", "seconds"})
+	for _, result := range results {
+		runs := make([]string, len(result.Seconds))
+		for i, s := range result.Seconds {
+			runs[i] = strconv.FormatFloat(s, 'f', 4, 64)
+		}
+		writer.Write([]string{
+			result.Mode, result.Dataset, strconv.Itoa(result.Threads),
+			strconv.FormatFloat(result.Mean, 'f', 4, 64),
+			strconv.FormatFloat(result.Stddev, 'f', 4, 64),
+			strconv.FormatFloat(result.Speedup, 'f', 3, 64),
+			strings.Join(runs, " "),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteJSON writes results as an indented JSON array.
+func WriteJSON(w io.Writer, results []Result) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+// plot colours, one per dataset in turn.
+var palette = []string{"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e", "#9467bd", "#8c564b"}
+
+// WriteSpeedupSVG plots the speedup of mode against the thread count, a
+// line per dataset, over the dashed line of linear speedup.
+func WriteSpeedupSVG(w io.Writer, results []Result, mode string) error {
+	const width, height = 640, 420
+	const left, right, top, bottom = 60, 130, 40, 50
+	plotW, plotH := float64(width-left-right), float64(height-top-bottom)
+
+	lines := map[string][]Result{}
+	var datasets []string
+	maxThreads, maxSpeedup := 1, 1.0
+	for _, result := range results {
+		if result.Mode != mode {
+			continue
+		}
+		if _, ok := lines[result.Dataset]; !ok {
+			datasets = append(datasets, result.Dataset)
+		}
+		lines[result.Dataset] = append(lines[result.Dataset], result)
+		if result.Threads > maxThreads {
+			maxThreads = result.Threads
+		}
+		if result.Speedup > maxSpeedup {
+			maxSpeedup = result.Speedup
+		}
+	}
+	if float64(maxThreads) > maxSpeedup {
+		maxSpeedup = float64(maxThreads)
+	}
+	x := func(threads float64) float64 { return left + threads/float64(maxThreads)*plotW }
+	y := func(speedup float64) float64 { return top + plotH - speedup/maxSpeedup*plotH }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`+"\n", width, height)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="white"/>`+"\n", width, height)
+	fmt.Fprintf(&b, `<text x="%d" y="24" font-size="16">Speedup of %s over sequential</text>`+"\n", left, mode)
+
+	// Axes with a tick per thread count and per unit of speedup.
+	fmt.Fprintf(&b, `<path d="M%d %d V%.1f H%.1f" stroke="black" fill="none"/>`+"\n", left, top, y(0), x(float64(maxThreads)))
+	ticks := map[int]bool{}
+	for _, result := range results {
+		if result.Mode == mode && !ticks[result.Threads] {
+			ticks[result.Threads] = true
+			fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%d</text>`+"\n", x(float64(result.Threads)), y(0)+18, result.Threads)
+		}
+	}
+	step := 1
+	for maxSpeedup/float64(step) > 10 {
+		step *= 2
+	}
+	for s := 0; float64(s) <= maxSpeedup; s += step {
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">%d</text>`+"\n", left-6, y(float64(s))+4, s)
+	}
+	fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">threads</text>`+"\n", x(float64(maxThreads)/2), height-10)
+	fmt.Fprintf(&b, `<text x="16" y="%.1f" text-anchor="middle" transform="rotate(-90 16 %.1f)">speedup</text>`+"\n", y(maxSpeedup/2), y(maxSpeedup/2))
+
+	fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="gray" stroke-dasharray="4 4"/>`+"\n",
+		x(0), y(0), x(float64(maxThreads)), y(float64(maxThreads)))
+	for i, dataset := range datasets {
+		colour := palette[i%len(palette)]
+		line := lines[dataset]
+		sort.Slice(line, func(i, j int) bool { return line[i].Threads < line[j].Threads })
+		var points []string
+		for _, result := range line {
+			px, py := x(float64(result.Threads)), y(result.Speedup)
+			points = append(points, fmt.Sprintf("%.1f,%.1f", px, py))
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"/>`+"\n", px, py, colour)
+		}
+		fmt.Fprintf(&b, `<polyline points="%s" stroke="%s" stroke-width="2" fill="none"/>`+"\n", strings.Join(points, " "), colour)
+		ly := top + 10 + 20*i
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="2"/>`+"\n", width-right+15, ly, width-right+35, ly, colour)
+		fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`+"\n", width-right+40, ly+4, escape(dataset))
+	}
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func escape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -1,16 +1,15 @@
 package concurrent
 
 import (
-	"sync"
-	"sync/atomic"
-	// "fmt"
 	"errors"
-
+	"sync/atomic"
 )
 
 type Task interface{}
 
-// Bounded Deque implements lock-free FIFO freelist based queue.
+// BDEQueue is a bounded double-ended queue of tasks. Only the goroutine that
+// owns the queue may call PushBottom and PopBottom; any goroutine may steal
+// with PopTop.
 type BDEQueue interface {
 	PushBottom(task Task) error
 	PopTop() Task
@@ -19,131 +18,118 @@ type BDEQueue interface {
 	IsEmpty() bool
 }
 
-
+// queue is the lock-free bounded deque of Arora, Blumofe and Plaxton. Tasks
+// live in tasks[top:bottom]. Thieves take tasks by moving top up with a CAS;
+// the owner pushes and pops at bottom, and only races the thieves for the
+// last task. When the queue empties the owner resets both ends to 0 (when it
+// pops the last task, or pushes onto the end of the array after thieves took
+// every task), so top
+// carries a stamp that changes on every update: a thief that read top before
+// the reset can't succeed with its stale CAS after the slot was reused.
 type queue struct {
-	top int64 // top stamp is the first 32 bits of top and top value is the last 32 bits of top
-	bottom int64 // bottom stamp is the first 32 bits of bottom and bottom value is the last 32 bits of bottom
-	tasks []Task
-	size     int
-	mtx      *sync.Mutex
-	capacity int
+	top    int64 // stamp in the upper 32 bits, index in the lower 32 bits
+	bottom int64 // index one past the last task, written only by the owner
+	tasks  []atomic.Pointer[Task]
 }
 
 func NewBoundedDEQueue(capacity int) BDEQueue {
-	// capacity is the size of the queue and it cannot be more than 2^32
-	// top and bottom are 32 bit integers
-	// assert(capacity < 1<<32, "Capacity cannot be more than 2^32")
-	queue := &queue{top: 0, bottom: 0, tasks: make([]Task, capacity), size: 0, mtx: &sync.Mutex{}, capacity: capacity}
-	return queue
+	// top packs its index into 32 bits
+	if capacity < 0 || capacity >= 1<<31 {
+		panic("Capacity must be less than 2^31")
+	}
+	return &queue{tasks: make([]atomic.Pointer[Task], capacity)}
+}
+
+func unpackTop(top int64) (index, stamp int64) {
+	return top & 0xFFFFFFFF, top >> 32
+}
+
+func packTop(index, stamp int64) int64 {
+	return index | stamp<<32
 }
 
+// Size returns the number of tasks in the queue. It may be stale as soon as
+// it returns when other goroutines use the queue.
 func (queue *queue) Size() int {
-	queue.mtx.Lock()
-	defer queue.mtx.Unlock()
-	return queue.size
+	top, _ := unpackTop(atomic.LoadInt64(&queue.top))
+	bottom := atomic.LoadInt64(&queue.bottom)
+	// The owner decrements bottom before racing for the last task and a
+	// thief may have moved top past it in the meantime.
+	if bottom <= top {
+		return 0
+	}
+	return int(bottom - top)
 }
 
-func (queue *queue) PopTop() Task {
-	// lock free pop top
- 	oldTop := atomic.LoadInt64(&queue.top)
-	oldStamp := (oldTop >> 32)
-	oldTop = (oldTop & 0xFFFFFFFF)
-	newTop := oldTop + 1
-	newStamp := oldStamp + 1
+func (queue *queue) IsEmpty() bool {
+	return queue.Size() == 0
+}
 
-	if ( (queue.bottom & 0xFFFFFFFF) <= oldTop) {
+// PopTop steals the task at the top of the queue. It returns nil when the
+// queue is empty or when another goroutine took the task first.
+func (queue *queue) PopTop() Task {
+	oldTop := atomic.LoadInt64(&queue.top)
+	index, stamp := unpackTop(oldTop)
+	if atomic.LoadInt64(&queue.bottom) <= index {
 		return nil
 	}
-
-	var t Task = queue.tasks[oldTop]
-	if (atomic.CompareAndSwapInt64(&queue.top, (oldTop | (oldStamp << 32)), (newTop | (newStamp << 32)))) {
-		queue.mtx.Lock()
-		queue.size -= 1
-		queue.mtx.Unlock()
-		return t
+	task := queue.tasks[index].Load()
+	if atomic.CompareAndSwapInt64(&queue.top, oldTop, packTop(index+1, stamp+1)) {
+		return *task
 	}
 	return nil
 }
 
+// PushBottom adds task at the bottom of the queue. It fails when bottom is at
+// the end of the array and tasks are still left above it.
 func (queue *queue) PushBottom(task Task) error {
-	// lock free push bottom
-	// Steps:
-	// Since only the bottom can push, we don't need to CAS the bottom
-	// We can just push the task to the bottom and increment the bottom
-
-	if (queue.bottom & 0xFFFFFFFF) > int64(queue.capacity) {
-		// queue is full
-		return errors.New("Full queue")
-
+	bottom := atomic.LoadInt64(&queue.bottom)
+	if bottom >= int64(len(queue.tasks)) {
+		// Thieves may have taken every task, which leaves top at bottom
+		// with no PopBottom to reset them. No steal can succeed on an
+		// empty queue, so the owner resets both ends the same way.
+		index, stamp := unpackTop(atomic.LoadInt64(&queue.top))
+		if index < bottom {
+			return errors.New("Full queue")
+		}
+		atomic.StoreInt64(&queue.bottom, 0)
+		atomic.StoreInt64(&queue.top, packTop(0, stamp+1))
+		bottom = 0
 	}
-	queue.tasks[(queue.bottom & 0xFFFFFFFF)] = task
-	queue.bottom += 1 | 1 << 32 // increment bottom and stamp
-	queue.mtx.Lock()
-	queue.size += 1
-	queue.mtx.Unlock()
+	queue.tasks[bottom].Store(&task)
+	// Publish the task only once it is in place.
+	atomic.StoreInt64(&queue.bottom, bottom+1)
 	return nil
 }
 
+// PopBottom takes the task most recently pushed. It returns nil when the
+// queue is empty, including when a thief won the race for the last task.
 func (queue *queue) PopBottom() Task {
-	// lock free pop bottom
-	// Steps:
-	// This would fail when the bottom is being popped by current thread and another thread is trying to steal
-	// So we need to CAS the bottom
-	// If CAS fails, the steal would succeed and we can simply return nil
-	// If CAS succeeds, we can return the task at the bottom
-
-	// check if queue is empty
-	if ((queue.bottom & 0xFFFFFFFF) == 0) {
+	bottom := atomic.LoadInt64(&queue.bottom)
+	if bottom == 0 {
 		return nil
 	}
+	// Claim the bottom task before looking at top, so a thief either sees
+	// the smaller bottom and leaves the task alone or got it already.
+	bottom--
+	atomic.StoreInt64(&queue.bottom, bottom)
+	task := queue.tasks[bottom].Load()
 
-	queue.bottom -= 1 // decrement bottom since we are popping 
-	var t Task = queue.tasks[queue.bottom & 0xFFFFFFFF]
-
-	// adjust top
 	oldTop := atomic.LoadInt64(&queue.top)
-	oldStamp := (oldTop >> 32)
-	oldTop = int64(oldTop & 0xFFFFFFFF)
-	
-	newTop := int64(0)
-	newStamp := oldStamp + 1
-
-	if ((queue.bottom & 0xFFFFFFFF) > oldTop) {
-		// reduce size
-		queue.mtx.Lock()
-		queue.size -= 1
-		queue.mtx.Unlock()
-		return t
+	index, stamp := unpackTop(oldTop)
+	if bottom > index {
+		// More than one task was left, no thief can reach this one.
+		return *task
 	}
 
-	if ((queue.bottom & 0xFFFFFFFF) == oldTop) {
-		queue.bottom = ((queue.bottom >> 32) << 32) // reset bottom to 0 while keeping the stamp
-		if (atomic.CompareAndSwapInt64(&queue.top, (oldTop | (oldStamp << 32)), (newTop | (newStamp << 32)))) {
-			queue.mtx.Lock()
-			queue.size -= 1
-			queue.mtx.Unlock()
-			return t
-		}
+	// The queue is empty after this pop, so reset both ends to 0. If this
+	// is the last task, win it from the thieves by moving top.
+	atomic.StoreInt64(&queue.bottom, 0)
+	emptyTop := packTop(0, stamp+1)
+	if bottom == index && atomic.CompareAndSwapInt64(&queue.top, oldTop, emptyTop) {
+		return *task
 	}
-
-	// if we reach here, we failed to pop bottom
-	queue.top = ((newStamp << 32) | newTop)
-	queue.bottom = ((queue.bottom >> 32) << 32) // reset bottom to 0 while keeping the stamp
-
-	// decrement size
-	queue.mtx.Lock()
-	queue.size -= 1
-	queue.mtx.Unlock()
-
+	// A thief took it; top can't change again until the next push.
+	atomic.StoreInt64(&queue.top, emptyTop)
 	return nil
 }
-
-func (queue *queue) IsEmpty() bool {
-	queue.mtx.Lock()
-	defer queue.mtx.Unlock()
-	if (queue.size == 0) {
-		return true
-	}
-	return false
-
-}
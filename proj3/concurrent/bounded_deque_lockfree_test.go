@@ -2,10 +2,13 @@ package concurrent
 
 import (
 	"sync"
+	"sync/atomic"
 	"testing"
-	// "fmt"
 )
 
+// Only the goroutine owning a queue may push and pop at its bottom; any
+// number of thieves may pop at its top.
+
 func TestBoundedDEQueue(t *testing.T) {
 	const capacity = 500
 	queue := NewBoundedDEQueue(capacity)
@@ -22,69 +25,61 @@ func TestBoundedDEQueue(t *testing.T) {
 	if task != "Task1" {
 		t.Errorf("Expected popped task %s, got %v", "Task1", task)
 	}
-
-	// Test concurrent push and pop operations
-	var wg sync.WaitGroup
-	const numGoroutines = 100
-
-	for i := 0; i < numGoroutines; i++ {
-		wg.Add(1)
-		go func(taskID int) {
-			defer wg.Done()
-			task := Task(taskID)
-			fmt.Println(queue.PushBottom(task))
-		}(i)
+	task = queue.PopBottom()
+	if task != "Task2" {
+		t.Errorf("Expected popped task %s, got %v", "Task2", task)
+	}
+	if task := queue.PopBottom(); task != nil || !queue.IsEmpty() {
+		t.Errorf("Expected an empty queue, popped %v", task)
 	}
 
-	wg.Wait()
-
-	// The size should be equal to the number of goroutines (numGoroutines)
-	if size := queue.Size(); size != numGoroutines+1 { // +1 for the initial push
-		t.Errorf("Expected size %d, got %d", numGoroutines+1, size)
+	// Test the owner popping the bottom while thieves pop the top
+	const numTasks = 400
+	const numThieves = 8
+	for i := 0; i < numTasks; i++ {
+		if err := queue.PushBottom(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if size := queue.Size(); size != numTasks {
+		t.Errorf("Expected size %d, got %d", numTasks, size)
 	}
 
-	// Test concurrent pop operations from both ends
-	var poppedTasksTop, poppedTasksBottom []Task
+	// The owner stops at the first nil from the bottom, which means the
+	// queue is empty, and then tells the thieves to stop too.
+	var wg sync.WaitGroup
+	var done int32
+	var poppedTasks []Task
 	var poppedTasksMutex sync.Mutex
-
-	for i := 0; i < numGoroutines; i++ {
+	for i := 0; i < numThieves; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			taskTop := queue.PopTop()
-			taskBottom := queue.PopBottom()
-			poppedTasksMutex.Lock()
-			if taskTop != nil {
-				poppedTasksTop = append(poppedTasksTop, taskTop)
-			}
-			if taskBottom != nil {
-				poppedTasksBottom = append(poppedTasksBottom, taskBottom)
+			for atomic.LoadInt32(&done) == 0 {
+				if task := queue.PopTop(); task != nil {
+					poppedTasksMutex.Lock()
+					poppedTasks = append(poppedTasks, task)
+					poppedTasksMutex.Unlock()
+				}
 			}
-			poppedTasksMutex.Unlock()
 		}()
 	}
-
+	for task := queue.PopBottom(); task != nil; task = queue.PopBottom() {
+		poppedTasksMutex.Lock()
+		poppedTasks = append(poppedTasks, task)
+		poppedTasksMutex.Unlock()
+	}
+	atomic.StoreInt32(&done, 1)
 	wg.Wait()
 
-	// The poppedTasksTop and poppedTasksBottom slices should each contain numGoroutines tasks
-	if len(poppedTasksTop) != numGoroutines {
-		t.Errorf("Expected %d popped tasks from top, got %d", numGoroutines, len(poppedTasksTop))
+	// Ensure that every task was popped exactly once
+	if len(poppedTasks) != numTasks {
+		t.Errorf("Expected %d popped tasks, got %d", numTasks, len(poppedTasks))
 	}
-	if len(poppedTasksBottom) != numGoroutines {
-		t.Errorf("Expected %d popped tasks from bottom, got %d", numGoroutines, len(poppedTasksBottom))
-	}
-
-	// Ensure that all popped tasks are unique
 	seenTasks := make(map[Task]struct{})
-	for _, task := range poppedTasksTop {
+	for _, task := range poppedTasks {
 		if _, exists := seenTasks[task]; exists {
-			t.Errorf("Duplicate popped task from top: %v", task)
-		}
-		seenTasks[task] = struct{}{}
-	}
-	for _, task := range poppedTasksBottom {
-		if _, exists := seenTasks[task]; exists {
-			t.Errorf("Duplicate popped task from bottom: %v", task)
+			t.Errorf("Duplicate popped task: %v", task)
 		}
 		seenTasks[task] = struct{}{}
 	}
@@ -95,7 +90,6 @@ func TestBoundedDEQueue(t *testing.T) {
 	}
 }
 
-
 func TestPopTopConcurrent(t *testing.T) {
 	const capacity = 500
 	queue := NewBoundedDEQueue(capacity)
@@ -108,7 +102,8 @@ func TestPopTopConcurrent(t *testing.T) {
 	var wg sync.WaitGroup
 	const numGoroutines = 100
 
-	// Test concurrent PopTop operations
+	// Test concurrent PopTop operations; a thief that loses a race for the
+	// top gets nil and tries again.
 	var poppedTasksTop []Task
 	var poppedTasksMutex sync.Mutex
 
@@ -117,10 +112,11 @@ func TestPopTopConcurrent(t *testing.T) {
 		go func() {
 			defer wg.Done()
 			taskTop := queue.PopTop()
-			poppedTasksMutex.Lock()
-			if taskTop != nil {
-				poppedTasksTop = append(poppedTasksTop, taskTop)
+			for taskTop == nil {
+				taskTop = queue.PopTop()
 			}
+			poppedTasksMutex.Lock()
+			poppedTasksTop = append(poppedTasksTop, taskTop)
 			poppedTasksMutex.Unlock()
 		}()
 	}
@@ -147,7 +143,6 @@ func TestPopTopConcurrent(t *testing.T) {
 	}
 }
 
-
 func TestPopTopAndPopBottomConcurrent(t *testing.T) {
 	const capacity = 4
 	queue := NewBoundedDEQueue(capacity)
@@ -158,61 +153,91 @@ func TestPopTopAndPopBottomConcurrent(t *testing.T) {
 	}
 
 	var wg sync.WaitGroup
-	const numGoroutines = 2
+	const numThieves = 2
 
-	// Test concurrent PopTop and PopBottom operations
+	// The owner pops the bottom while the thieves pop the top
 	var poppedTasksTop, poppedTasksBottom []Task
 	var poppedTasksMutex sync.Mutex
 
-	for i := 0; i < numGoroutines; i++ {
+	for i := 0; i < numThieves; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			taskTop := queue.PopTop()
-			taskBottom := queue.PopBottom()
-
 			poppedTasksMutex.Lock()
 			if taskTop != nil {
 				poppedTasksTop = append(poppedTasksTop, taskTop)
 			}
-			if taskBottom != nil {
-				poppedTasksBottom = append(poppedTasksBottom, taskBottom)
-			}
 			poppedTasksMutex.Unlock()
 		}()
 	}
+	for i := 0; i < numThieves; i++ {
+		if taskBottom := queue.PopBottom(); taskBottom != nil {
+			poppedTasksMutex.Lock()
+			poppedTasksBottom = append(poppedTasksBottom, taskBottom)
+			poppedTasksMutex.Unlock()
+		}
+	}
 
 	wg.Wait()
 
-	// The poppedTasksTop and poppedTasksBottom slices should each contain numGoroutines tasks
-	if len(poppedTasksTop) != numGoroutines {
-		t.Errorf("Expected %d popped tasks from top, got %d", numGoroutines, len(poppedTasksTop))
-	}
-	if len(poppedTasksBottom) != numGoroutines {
-		t.Errorf("Expected %d popped tasks from bottom, got %d", numGoroutines, len(poppedTasksBottom))
-	}
-
-	// Ensure that all popped tasks are unique
+	// No task may be popped from both ends, and the ones not popped must
+	// still be in the queue
 	seenTasks := make(map[Task]struct{})
-	for _, task := range poppedTasksTop {
+	for _, task := range append(poppedTasksTop, poppedTasksBottom...) {
 		if _, exists := seenTasks[task]; exists {
-			t.Errorf("Duplicate popped task from top: %v", task)
+			t.Errorf("Duplicate popped task: %v", task)
 		}
 		seenTasks[task] = struct{}{}
 	}
-	for _, task := range poppedTasksBottom {
+	for task := queue.PopBottom(); task != nil; task = queue.PopBottom() {
 		if _, exists := seenTasks[task]; exists {
-			t.Errorf("Duplicate popped task from bottom: %v", task)
+			t.Errorf("Popped task %v still in the queue", task)
 		}
 		seenTasks[task] = struct{}{}
 	}
+	if len(seenTasks) != capacity {
+		t.Errorf("Expected %d tasks in all, got %d", capacity, len(seenTasks))
+	}
+}
 
-	// Ensure the size is correct after all pops
-	if size := queue.Size(); size != capacity-numGoroutines*2 {
-		t.Errorf("Expected size %d, got %d", capacity-numGoroutines*2, size)
+func TestBoundedDEQueueFull(t *testing.T) {
+	const capacity = 3
+	queue := NewBoundedDEQueue(capacity)
+	for i := 0; i < capacity; i++ {
+		if err := queue.PushBottom(i); err != nil {
+			t.Fatalf("PushBottom(%d): %v", i, err)
+		}
+	}
+	if err := queue.PushBottom(capacity); err == nil {
+		t.Errorf("Expected an error pushing onto a full queue")
+	}
+
+	// Emptying the queue makes room again
+	for queue.PopBottom() != nil {
+	}
+	if err := queue.PushBottom("Task"); err != nil {
+		t.Errorf("PushBottom on an emptied queue: %v", err)
+	}
+	if size := queue.Size(); size != 1 {
+		t.Errorf("Expected size %d, got %d", 1, size)
+	}
+
+	// So does stealing every task, which the owner never sees
+	for queue.PushBottom("Task") == nil {
+	}
+	for queue.PopTop() != nil {
+	}
+	for i := 0; i < capacity; i++ {
+		if err := queue.PushBottom(i); err != nil {
+			t.Fatalf("PushBottom(%d) on a stolen-empty queue: %v", i, err)
+		}
+	}
+	if task := queue.PopTop(); task != 0 {
+		t.Errorf("Expected popped task %d, got %v", 0, task)
 	}
 }
 
 func TestMain(m *testing.M) {
 	m.Run()
-}
\ No newline at end of file
+}
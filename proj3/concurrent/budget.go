@@ -0,0 +1,99 @@
+package concurrent
+
+import (
+	"proj3/png"
+	"sync"
+	"time"
+)
+
+// memoryBudget hands out bytes of a fixed budget to the tasks of a run,
+// blocking those that would exceed it until others give their bytes back.
+// Buffers that finished tasks leave idle in pool count against the budget
+// too: each acquire trims them to what is still free.
+// A nil *memoryBudget hands out any amount at once.
+type memoryBudget struct {
+	mtx   sync.Mutex
+	freed *sync.Cond
+	free  int64
+	total int64
+	peak  int64 // most bytes taken at once
+	pool  *png.BufferPool
+}
+
+func newMemoryBudget(total int64, pool *png.BufferPool) *memoryBudget {
+	if total <= 0 {
+		return nil
+	}
+	budget := &memoryBudget{free: total, total: total, pool: pool}
+	budget.freed = sync.NewCond(&budget.mtx)
+	return budget
+}
+
+// acquire blocks until n bytes are free and takes them, returning how many
+// it took. A task larger than the whole budget waits for every other task to
+// finish and then runs alone.
+func (budget *memoryBudget) acquire(n int64) int64 {
+	if budget == nil {
+		return 0
+	}
+	if n > budget.total {
+		n = budget.total
+	}
+	budget.mtx.Lock()
+	for budget.free < n {
+		budget.freed.Wait()
+	}
+	budget.free -= n
+	if used := budget.total - budget.free; used > budget.peak {
+		budget.peak = used
+	}
+	budget.pool.Trim(budget.free)
+	budget.mtx.Unlock()
+	return n
+}
+
+// release gives back n bytes taken by acquire.
+func (budget *memoryBudget) release(n int64) {
+	if budget == nil || n == 0 {
+		return
+	}
+	budget.mtx.Lock()
+	budget.free += n
+	budget.mtx.Unlock()
+	budget.freed.Broadcast()
+}
+
+// maxUsed returns the most bytes taken at once so far.
+func (budget *memoryBudget) maxUsed() int64 {
+	if budget == nil {
+		return 0
+	}
+	budget.mtx.Lock()
+	defer budget.mtx.Unlock()
+	return budget.peak
+}
+
+// reserve takes the bytes request is estimated to need from its budget,
+// as processed in strips if it asks to and can be and otherwise whole, and
+// returns how many to release once the task is done. Files that can't be
+// read are let through to fail when they are loaded.
+func reserve(request Request, worker int) int64 {
+	if request.budget == nil {
+		return 0
+	}
+	in, out := request.paths()
+	stripRows := 0
+	if request.StripRows > 0 && png.Tileable(in, out, request.Effects, request.Save) == nil {
+		stripRows = request.StripRows
+	}
+	n, err := png.MemoryEstimate(in, request.Effects, stripRows, request.inDir)
+	if err != nil {
+		return 0
+	}
+	start := time.Now()
+	n = request.budget.acquire(n)
+	if wait := time.Since(start); wait > time.Millisecond {
+		request.trace.span(worker, "memory", "wait for memory", start, map[string]interface{}{"task": request.name(), "bytes": n})
+	}
+	return n
+}
@@ -0,0 +1,122 @@
+package concurrent
+
+import (
+	"image"
+	"math/rand"
+	"path/filepath"
+	"proj3/png"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	const total = 100
+	budget := newMemoryBudget(total, nil)
+	var inUse, peak int64
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for j := 0; j < 50; j++ {
+				n := budget.acquire(int64(rng.Intn(150)))
+				now := atomic.AddInt64(&inUse, n)
+				for {
+					old := atomic.LoadInt64(&peak)
+					if now <= old || atomic.CompareAndSwapInt64(&peak, old, now) {
+						break
+					}
+				}
+				time.Sleep(time.Duration(rng.Intn(50)) * time.Microsecond)
+				atomic.AddInt64(&inUse, -n)
+				budget.release(n)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	if peak > total || budget.maxUsed() > total {
+		t.Errorf("held %d bytes (budget reports %d) of a budget of %d", peak, budget.maxUsed(), total)
+	}
+	if budget.free != total {
+		t.Errorf("%d bytes free after every task finished, want %d", budget.free, total)
+	}
+}
+
+func TestMemoryBudgetBlocks(t *testing.T) {
+	budget := newMemoryBudget(10, nil)
+	held := budget.acquire(8)
+	acquired := make(chan int64)
+	go func() { acquired <- budget.acquire(5) }()
+	select {
+	case <-acquired:
+		t.Fatal("acquired more than was free")
+	case <-time.After(20 * time.Millisecond):
+	}
+	budget.release(held)
+	if n := <-acquired; n != 5 {
+		t.Errorf("acquired %d bytes, want 5", n)
+	}
+	// A task larger than the budget runs once it is alone.
+	budget.release(5)
+	if n := budget.acquire(1000); n != 10 {
+		t.Errorf("oversized task took %d bytes, want the whole budget of 10", n)
+	}
+
+	var nilBudget *memoryBudget
+	if n := nilBudget.acquire(1 << 40); n != 0 {
+		t.Errorf("no budget took %d bytes", n)
+	}
+	nilBudget.release(0)
+}
+
+func TestMemoryBudgetTrimsPool(t *testing.T) {
+	pool := png.NewBufferPool(0)
+	for i := 0; i < 4; i++ {
+		pool.Put(pool.Get(image.Rect(0, 0, 16, 8)))
+	}
+	budget := newMemoryBudget(4096, pool)
+	if got := pool.Idle(); got != 1024 {
+		t.Fatalf("idle %d bytes, want the one 1024 byte buffer Get kept reusing", got)
+	}
+	for i := 0; i < 3; i++ {
+		pool.Put(png.NewBufferPool(0).Get(image.Rect(0, 0, 16, 8)))
+	}
+	held := budget.acquire(3000)
+	// Idle buffers and reserved bytes together stay within the budget.
+	if got := pool.Idle(); got+held > 4096 {
+		t.Errorf("%d bytes idle beside %d reserved of a budget of 4096", got, held)
+	}
+	budget.release(held)
+}
+
+func TestScheduleWithinBudget(t *testing.T) {
+	root := t.TempDir()
+	// Every task is estimated at a little over the 1 MiB png.MemoryEstimate
+	// allows for the decoder; two of them fit.
+	const budget = 3 << 20
+	config := Config{
+		DataDirs:     "small+big",
+		ThreadCount:  4,
+		EffectsFiles: []string{writeDataset(t, root, "small", "big")},
+		InDir:        filepath.Join(root, "in"),
+		MemoryBudget: budget,
+	}
+	report, err := Verify(config, []string{"s", "ws", "mr", "pipe"}, filepath.Join(root, "verify"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || len(report.Reference) != 8 {
+		t.Errorf("outputs under a memory budget differ: %+v", report)
+	}
+	for _, mode := range []string{"ws", "mr", "pipe"} {
+		config.Mode = mode
+		config.OutDir = filepath.Join(root, "out")
+		stats := Schedule(config)
+		if stats.MemoryPeak <= 0 || stats.MemoryPeak > budget {
+			t.Errorf("%s: reserved %d bytes at once with a budget of %d", mode, stats.MemoryPeak, budget)
+		}
+	}
+}
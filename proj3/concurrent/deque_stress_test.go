@@ -0,0 +1,355 @@
+package concurrent
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// A randomized linearizability test for BDEQueue implementations. Each run
+// has one owner goroutine pushing and popping at the bottom, as the work
+// stealing executor does with its own queue, and several thieves popping at
+// the top. Every operation is recorded with the logical times it was called
+// and returned, and the history must be explainable by a sequential deque:
+// some order of the operations that respects real time (an operation that
+// returned before another was called comes first) and in which every result
+// matches the model. Run it with -race; -deque-seeds sets the number of runs.
+
+var dequeSeeds = flag.Int("deque-seeds", 200, "number of random workloads per BDEQueue stress test")
+
+type dequeOpKind int
+
+const (
+	pushBottomOp dequeOpKind = iota
+	popBottomOp
+	popTopOp
+)
+
+func (kind dequeOpKind) String() string {
+	return [...]string{"PushBottom", "PopBottom", "PopTop"}[kind]
+}
+
+// dequeOp is one completed operation of a history. value is the task pushed
+// or popped, -1 for a pop that returned nil.
+type dequeOp struct {
+	kind      dequeOpKind
+	value     int
+	call, ret int64
+	mayAbort  bool // a nil PopTop that raced with another pop, see abortable
+}
+
+func (op dequeOp) String() string {
+	return fmt.Sprintf("%v(%d)@[%d,%d]", op.kind, op.value, op.call, op.ret)
+}
+
+// recorder stamps operations with a shared logical clock.
+type recorder struct {
+	clock int64
+}
+
+func (r *recorder) do(kind dequeOpKind, f func() int) dequeOp {
+	op := dequeOp{kind: kind, call: atomic.AddInt64(&r.clock, 1)}
+	op.value = f()
+	op.ret = atomic.AddInt64(&r.clock, 1)
+	return op
+}
+
+func taskValue(task Task) int {
+	if task == nil {
+		return -1
+	}
+	return task.(int)
+}
+
+// dequeWorkload describes one stress run.
+type dequeWorkload struct {
+	thieves      int
+	ownerOps     int     // operations of the owner before it drains the queue
+	thiefOps     int     // PopTop calls per thief
+	pushFraction float64 // share of the owner's operations that push
+}
+
+// runDequeWorkload runs workload on queue with randomness from seed and
+// returns the history of each goroutine, the owner's first. The owner ends
+// by draining the queue once the thieves are done, so every pushed task is
+// popped in the history. The capacity of queue must exceed the number of
+// owner operations.
+func runDequeWorkload(queue BDEQueue, workload dequeWorkload, seed int64) ([][]dequeOp, error) {
+	var r recorder
+	histories := make([][]dequeOp, workload.thieves+1)
+
+	var start, thieves sync.WaitGroup
+	start.Add(1)
+	for i := 1; i <= workload.thieves; i++ {
+		thieves.Add(1)
+		go func(i int) {
+			defer thieves.Done()
+			rng := rand.New(rand.NewSource(seed*31 + int64(i)))
+			start.Wait()
+			for j := 0; j < workload.thiefOps; j++ {
+				if rng.Intn(4) == 0 {
+					runtime.Gosched()
+				}
+				histories[i] = append(histories[i], r.do(popTopOp, func() int { return taskValue(queue.PopTop()) }))
+			}
+		}(i)
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	var pushErr error
+	next := 0
+	start.Done()
+	for j := 0; j < workload.ownerOps; j++ {
+		if rng.Intn(4) == 0 {
+			runtime.Gosched()
+		}
+		if rng.Float64() < workload.pushFraction {
+			value := next
+			next++
+			histories[0] = append(histories[0], r.do(pushBottomOp, func() int {
+				if err := queue.PushBottom(value); err != nil && pushErr == nil {
+					pushErr = fmt.Errorf("PushBottom(%d): %v", value, err)
+				}
+				return value
+			}))
+		} else {
+			histories[0] = append(histories[0], r.do(popBottomOp, func() int { return taskValue(queue.PopBottom()) }))
+		}
+	}
+	thieves.Wait()
+	if pushErr != nil {
+		return histories, pushErr
+	}
+
+	// The queue is quiescent: its size must match what is left in it.
+	size, empty := queue.Size(), queue.IsEmpty()
+	drained := 0
+	for {
+		op := r.do(popBottomOp, func() int { return taskValue(queue.PopBottom()) })
+		histories[0] = append(histories[0], op)
+		if op.value < 0 {
+			break
+		}
+		drained++
+	}
+	if size != drained || empty != (drained == 0) {
+		return histories, fmt.Errorf("quiescent queue holding %d tasks reports Size %d, IsEmpty %v", drained, size, empty)
+	}
+	return histories, nil
+}
+
+// abortable marks the nil PopTops that overlap a successful pop. A thief may
+// give up when it loses a race for the top, even if the queue wasn't empty.
+func abortable(histories [][]dequeOp) {
+	for i, history := range histories {
+		for j := range history {
+			op := &history[j]
+			if op.kind != popTopOp || op.value >= 0 {
+				continue
+			}
+			for k, other := range histories {
+				if k == i {
+					continue
+				}
+				for _, o := range other {
+					if o.kind != pushBottomOp && o.value >= 0 && o.call < op.ret && op.call < o.ret {
+						op.mayAbort = true
+					}
+				}
+			}
+		}
+	}
+}
+
+// linearizable reports whether the per-goroutine histories can be ordered
+// into a sequential deque history that respects real time. It searches the
+// orders depth first, remembering states already found to be dead ends.
+func linearizable(histories [][]dequeOp) bool {
+	next := make([]int, len(histories))
+	var model []int
+	failed := map[string]bool{}
+
+	var search func() bool
+	search = func() bool {
+		// An operation can go next only if it was called before every other
+		// pending operation returned.
+		minRet, done := int64(-1), true
+		for i, history := range histories {
+			if next[i] < len(history) {
+				done = false
+				if ret := history[next[i]].ret; minRet < 0 || ret < minRet {
+					minRet = ret
+				}
+			}
+		}
+		if done {
+			return true
+		}
+		key := fmt.Sprint(next, model)
+		if failed[key] {
+			return false
+		}
+		for i, history := range histories {
+			if next[i] == len(history) || history[next[i]].call > minRet {
+				continue
+			}
+			op := history[next[i]]
+			saved := model
+			ok := true
+			switch {
+			case op.kind == pushBottomOp:
+				model = append(model[:len(model):len(model)], op.value)
+			case op.value < 0:
+				ok = len(model) == 0 || op.mayAbort
+			case len(model) == 0:
+				ok = false
+			case op.kind == popBottomOp:
+				ok = model[len(model)-1] == op.value
+				model = model[:len(model)-1]
+			default:
+				ok = model[0] == op.value
+				model = model[1:]
+			}
+			if ok {
+				next[i]++
+				if search() {
+					return true
+				}
+				next[i]--
+			}
+			model = saved
+		}
+		failed[key] = true
+		return false
+	}
+	return search()
+}
+
+func formatHistories(histories [][]dequeOp) string {
+	var b strings.Builder
+	for i, history := range histories {
+		if i == 0 {
+			b.WriteString("owner:")
+		} else {
+			fmt.Fprintf(&b, "thief %d:", i)
+		}
+		for _, op := range history {
+			fmt.Fprintf(&b, " %v", op)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// stressDeque runs random workloads against queues made by newQueue and
+// fails on the first history that isn't linearizable.
+func stressDeque(t *testing.T, newQueue func(capacity int) BDEQueue) {
+	workloads := []dequeWorkload{
+		{thieves: 1, ownerOps: 40, thiefOps: 20, pushFraction: 0.6},
+		{thieves: 3, ownerOps: 60, thiefOps: 15, pushFraction: 0.7},
+		{thieves: 6, ownerOps: 30, thiefOps: 6, pushFraction: 0.5},
+	}
+	seeds := *dequeSeeds
+	if testing.Short() {
+		seeds /= 10
+	}
+	for seed := int64(0); seed < int64(seeds); seed++ {
+		workload := workloads[seed%int64(len(workloads))]
+		histories, err := runDequeWorkload(newQueue(workload.ownerOps+1), workload, seed)
+		if err != nil {
+			t.Fatalf("seed %d: %v\n%s", seed, err, formatHistories(histories))
+		}
+		abortable(histories)
+		if !linearizable(histories) {
+			t.Fatalf("seed %d: history is not linearizable\n%s", seed, formatHistories(histories))
+		}
+	}
+}
+
+// lockedDeque is a BDEQueue guarded by a mutex, linearizable by
+// construction, used to check the harness itself.
+type lockedDeque struct {
+	mtx      sync.Mutex
+	tasks    []Task
+	capacity int
+}
+
+func (q *lockedDeque) PushBottom(task Task) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if len(q.tasks) >= q.capacity {
+		return fmt.Errorf("full queue")
+	}
+	q.tasks = append(q.tasks, task)
+	return nil
+}
+
+func (q *lockedDeque) PopTop() Task {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if len(q.tasks) == 0 {
+		return nil
+	}
+	task := q.tasks[0]
+	q.tasks = q.tasks[1:]
+	return task
+}
+
+func (q *lockedDeque) PopBottom() Task {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if len(q.tasks) == 0 {
+		return nil
+	}
+	task := q.tasks[len(q.tasks)-1]
+	q.tasks = q.tasks[:len(q.tasks)-1]
+	return task
+}
+
+func (q *lockedDeque) Size() int {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return len(q.tasks)
+}
+
+func (q *lockedDeque) IsEmpty() bool { return q.Size() == 0 }
+
+func TestStressLockedDeque(t *testing.T) {
+	stressDeque(t, func(capacity int) BDEQueue { return &lockedDeque{capacity: capacity} })
+}
+
+func TestStressBoundedDEQueue(t *testing.T) {
+	stressDeque(t, NewBoundedDEQueue)
+}
+
+func TestLinearizableRejects(t *testing.T) {
+	push := func(v int, call, ret int64) dequeOp { return dequeOp{pushBottomOp, v, call, ret, false} }
+	popBottom := func(v int, call, ret int64) dequeOp { return dequeOp{popBottomOp, v, call, ret, false} }
+	popTop := func(v int, call, ret int64) dequeOp { return dequeOp{popTopOp, v, call, ret, false} }
+	tests := []struct {
+		name      string
+		histories [][]dequeOp
+		want      bool
+	}{
+		{"fifo at top", [][]dequeOp{{push(0, 1, 2), push(1, 3, 4)}, {popTop(0, 5, 6), popTop(1, 7, 8)}}, true},
+		{"lifo at top", [][]dequeOp{{push(0, 1, 2), push(1, 3, 4)}, {popTop(1, 5, 6)}}, false},
+		{"lifo at bottom", [][]dequeOp{{push(0, 1, 2), push(1, 3, 4), popBottom(1, 5, 6), popBottom(0, 7, 8)}}, true},
+		{"popped twice", [][]dequeOp{{push(0, 1, 2), popBottom(0, 3, 6)}, {popTop(0, 4, 5)}}, false},
+		{"overlapping thief wins", [][]dequeOp{{push(0, 1, 2), popBottom(-1, 3, 6)}, {popTop(0, 4, 5)}}, true},
+		{"lost task", [][]dequeOp{{push(0, 1, 2), popBottom(-1, 3, 4)}}, false},
+		{"pop before push", [][]dequeOp{{push(0, 3, 4)}, {popTop(0, 1, 2)}}, false},
+		{"thief loses race", [][]dequeOp{{push(0, 1, 2), push(1, 3, 4)}, {popTop(0, 5, 8)}, {popTop(-1, 6, 7)}}, true},
+		{"nil top while full", [][]dequeOp{{push(0, 1, 2)}, {popTop(-1, 3, 4)}}, false},
+	}
+	for _, test := range tests {
+		abortable(test.histories)
+		if got := linearizable(test.histories); got != test.want {
+			t.Errorf("%s: linearizable = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package concurrent
+
+import (
+	"fmt"
+	"sync"
+)
+
+// failureLog records the requests of a run that panicked, so that the run
+// goes on with the others. A nil *failureLog lets the panics through.
+type failureLog struct {
+	mtx      sync.Mutex
+	failures []string
+}
+
+func newFailureLog(keepGoing bool) *failureLog {
+	if !keepGoing {
+		return nil
+	}
+	return &failureLog{}
+}
+
+// catch, deferred by the code running request, stops a panic of request and
+// records it. On a nil log it doesn't recover, so the panic goes on.
+func (log *failureLog) catch(request Request) {
+	if log == nil {
+		return
+	}
+	if r := recover(); r != nil {
+		log.mtx.Lock()
+		log.failures = append(log.failures, fmt.Sprintf("%s: %v", request.name(), r))
+		log.mtx.Unlock()
+	}
+}
+
+// list returns the failures recorded so far.
+func (log *failureLog) list() []string {
+	if log == nil {
+		return nil
+	}
+	log.mtx.Lock()
+	defer log.mtx.Unlock()
+	return append([]string(nil), log.failures...)
+}
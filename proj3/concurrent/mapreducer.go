@@ -2,16 +2,16 @@ package concurrent
 
 import (
 	"encoding/json"
-	"fmt"
 	"os"
 	"strings"
 	"sync"
 )
 
-func mapper(config Config, fileNo int, ch chan map[string][]MapReducer) {
-	filePath := "../data/effects" + fmt.Sprint(fileNo) + ".txt"
-
-	file, _ := os.Open(filePath)
+func mapper(config Config, filePath string, ch chan map[string][]MapReducer) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		panic(err)
+	}
 	fileReader := json.NewDecoder(file)
 	resultMap := make(map[string][]MapReducer)
 
@@ -22,9 +22,14 @@ func mapper(config Config, fileNo int, ch chan map[string][]MapReducer) {
 			panic(err)
 		}
 
+		// Requests without a region are reduced on their own.
+		key := currReq.Region
+		if key == "" {
+			key = "\x00" + currReq.InPath
+		}
 		for _, dir := range strings.Split(config.DataDirs, "+") {
-			currReq.dataDir = dir
-			resultMap[currReq.Region] = append(resultMap[currReq.Region], currReq)
+			config.bind(&currReq.Request, dir)
+			resultMap[key] = append(resultMap[key], currReq)
 		}
 	}
 
@@ -32,9 +37,9 @@ func mapper(config Config, fileNo int, ch chan map[string][]MapReducer) {
 
 }
 
-func reducer(imgArr []MapReducer, wg *sync.WaitGroup) {
+func reducer(imgArr []MapReducer, wg *sync.WaitGroup, worker int) {
 	for _, imgTask := range imgArr {
-		processImage(Request{imgTask.InPath, imgTask.OutPath, imgTask.Effects, imgTask.dataDir}, imgTask.dataDir)
+		processImage(imgTask.Request, imgTask.dataDir, worker)
 	}
 	// wg.Done()
 }
@@ -70,7 +75,7 @@ func reducer(imgArr []MapReducer, wg *sync.WaitGroup) {
 
 // }
 
-func shuffler(intermediateMap []map[string][]MapReducer, config Config) {
+func shuffler(intermediateMap []map[string][]MapReducer, config Config) []WorkerStats {
 	// Steps:
 	// 1. Create a map to store the shuffled results by key
 	// 2. Create a channel to send the shuffled results to
@@ -88,6 +93,7 @@ func shuffler(intermediateMap []map[string][]MapReducer, config Config) {
 
 	ch := make(chan []MapReducer, len(shuffledResults))
 	wg := &sync.WaitGroup{}
+	stats := make([]WorkerStats, config.ThreadCount)
 
 	for _, value := range shuffledResults {
 		ch <- value
@@ -100,6 +106,10 @@ func shuffler(intermediateMap []map[string][]MapReducer, config Config) {
 		go func(threadID int) {
 			// remove one entry from the channel and process it until the channel is empty
 			defer wg.Done() // Ensure that wg.Done() is called when the goroutine exits
+			workerStats := &stats[threadID]
+			workerStats.Worker = threadID
+			clock := startClock(workerStats)
+			defer clock.stop()
 	
 			for {
 				select {
@@ -109,7 +119,11 @@ func shuffler(intermediateMap []map[string][]MapReducer, config Config) {
 						return
 					}
 					// fmt.Println("Thread", threadID, "is processing", key1)
-					reducer(key1, wg)
+					if queued := len(ch) + 1; queued > workerStats.QueueHighWater {
+						workerStats.QueueHighWater = queued
+					}
+					workerStats.LocalTasks += len(key1)
+					clock.busy(func() { reducer(key1, wg, threadID) })
 				default:
 					// Channel is empty, exit the goroutine
 					return
@@ -120,4 +134,5 @@ func shuffler(intermediateMap []map[string][]MapReducer, config Config) {
 	
 
 	wg.Wait()
+	return stats
 }
\ No newline at end of file
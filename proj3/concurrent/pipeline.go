@@ -0,0 +1,133 @@
+package concurrent
+
+import (
+	"encoding/json"
+	"os"
+	"proj3/png"
+	"strings"
+	"sync"
+)
+
+// pipelineItem is a request on its way through the pipeline. img is nil
+// until it is decoded, and stays nil for requests processed in strips. held
+// is the memory reserved for it, released once it is saved.
+type pipelineItem struct {
+	request Request
+	img     *png.Image
+	held    int64
+}
+
+// pipelineStage runs the stage work on every item of in with workers
+// goroutines numbered from firstWorker, sending what work returns to out,
+// if any, and closes out once in is drained. work returns false to drop an
+// item; an item whose work panics under Config.KeepGoing is dropped too and
+// gives back the memory it held.
+func pipelineStage(stage string, workers, firstWorker int, in <-chan pipelineItem, out chan<- pipelineItem,
+	stats []WorkerStats, work func(item *pipelineItem, worker int) bool) {
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			workerStats := &stats[worker]
+			workerStats.Worker = worker
+			workerStats.Stage = stage
+			clock := startClock(workerStats)
+			defer clock.stop()
+			for item := range in {
+				if queued := len(in); queued > workerStats.QueueHighWater {
+					workerStats.QueueHighWater = queued
+				}
+				keep, failed := false, true
+				clock.busy(func() {
+					defer item.request.failures.catch(item.request)
+					keep = work(&item, worker)
+					failed = false
+				})
+				if failed {
+					// Only reached under Config.KeepGoing.
+					item.request.budget.release(item.held)
+				}
+				workerStats.LocalTasks += 1
+				if keep && out != nil {
+					out <- item
+				}
+			}
+		}(firstWorker + i)
+	}
+	go func() {
+		wg.Wait()
+		if out != nil {
+			close(out)
+		}
+	}()
+}
+
+// RunPipeline splits every request into decoding, running the effects and
+// encoding, each done by its own pool of goroutines, so the zlib work of
+// one image overlaps the convolutions of another. The pools are joined by
+// queues of config.QueueDepth images, which bound how many decoded images
+// wait in memory. Requests processed in strips run whole in the effects
+// pool. Workers are numbered through the decode, effects and encode pools
+// in turn.
+func RunPipeline(config Config) []WorkerStats {
+	config = config.withDefaults()
+	decoders, effects, encoders := config.DecodeWorkers, config.EffectWorkers, config.EncodeWorkers
+	stats := make([]WorkerStats, decoders+effects+encoders)
+
+	requests := make(chan pipelineItem, config.QueueDepth)
+	decoded := make(chan pipelineItem, config.QueueDepth)
+	processed := make(chan pipelineItem, config.QueueDepth)
+	done := make(chan pipelineItem)
+
+	pipelineStage("decode", decoders, 0, requests, decoded, stats, func(item *pipelineItem, worker int) bool {
+		item.held = reserve(item.request, worker)
+		if item.request.StripRows <= 0 {
+			item.img = loadStage(item.request, worker)
+		}
+		return true
+	})
+	pipelineStage("effects", effects, decoders, decoded, processed, stats, func(item *pipelineItem, worker int) bool {
+		if item.img == nil {
+			if runStrips(item.request, worker) {
+				item.request.budget.release(item.held)
+				return false
+			}
+			item.img = loadStage(item.request, worker)
+		}
+		effectsStage(item.img, item.request, worker)
+		return true
+	})
+	pipelineStage("encode", encoders, decoders+effects, processed, done, stats, func(item *pipelineItem, worker int) bool {
+		saveStage(item.img, item.request, worker)
+		item.request.budget.release(item.held)
+		return false
+	})
+
+	dataDirs := strings.Split(config.DataDirs, "+")
+	for _, effectsPathFile := range config.EffectsFiles {
+		effectsFile, err := os.Open(effectsPathFile)
+		if err != nil {
+			panic(err)
+		}
+		reader := json.NewDecoder(effectsFile)
+
+		for reader.More() {
+			var request Request
+			err := reader.Decode(&request)
+			if err != nil {
+				panic(err)
+			}
+			for _, dataDir := range dataDirs {
+				config.bind(&request, dataDir)
+				requests <- pipelineItem{request: request}
+			}
+		}
+		effectsFile.Close()
+	}
+	close(requests)
+	// done is closed once the encoders have finished.
+	for range done {
+	}
+	return stats
+}
@@ -0,0 +1,65 @@
+package concurrent
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPipeline(t *testing.T) {
+	root := t.TempDir()
+	config := Config{
+		DataDirs:      "small+big",
+		ThreadCount:   2,
+		EffectsFiles:  []string{writeDataset(t, root, "small", "big")},
+		InDir:         filepath.Join(root, "in"),
+		DecodeWorkers: 1,
+		EffectWorkers: 3,
+		EncodeWorkers: 2,
+		QueueDepth:    1,
+	}
+	// Every request passes through the decode pool, but the decode workers
+	// only reserve memory for the two strip requests, which the effects pool
+	// then runs whole, so they never reach the encode pool.
+	tasks := []int{8, 8, 6}
+	for _, pools := range [][3]int{{1, 3, 2}, {0, 0, 0}} {
+		config.DecodeWorkers, config.EffectWorkers, config.EncodeWorkers = pools[0], pools[1], pools[2]
+		report, err := Verify(config, []string{"s", "pipe"}, filepath.Join(root, "verify"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.OK() || len(report.Reference) != 8 {
+			t.Errorf("pools %v: pipeline outputs differ: %+v", pools, report)
+		}
+
+		config.Mode = "pipe"
+		config.OutDir = filepath.Join(root, "out")
+		stats := Schedule(config)
+		if pools[0] == 0 {
+			// Defaults for two threads.
+			pools = [3]int{1, 2, 1}
+		}
+		if len(stats.Workers) != pools[0]+pools[1]+pools[2] {
+			t.Fatalf("pools %v: %d workers", pools, len(stats.Workers))
+		}
+		if stats.Tasks() != 8 {
+			t.Errorf("pools %v: %d tasks, want 8", pools, stats.Tasks())
+		}
+		worker := 0
+		for stage, n := range pools {
+			handled := 0
+			for i := 0; i < n; i++ {
+				handled += stats.Workers[worker].LocalTasks
+				if w := stats.Workers[worker]; w.Worker != worker || w.Stage != []string{"decode", "effects", "encode"}[stage] {
+					t.Errorf("worker %d is %s %d", worker, w.Stage, w.Worker)
+				}
+				if q := stats.Workers[worker].QueueHighWater; q > 1 {
+					t.Errorf("worker %d saw %d queued images with a queue depth of 1", worker, q)
+				}
+				worker++
+			}
+			if handled != tasks[stage] {
+				t.Errorf("pools %v: stage %d handled %d tasks, want %d", pools, stage, handled, tasks[stage])
+			}
+		}
+	}
+}
@@ -3,63 +3,188 @@ package concurrent
 import (
 	"encoding/json"
 	"os"
+	"proj3/png"
 	"strings"
+	"time"
 )
 
 type Config struct {
 	DataDirs string 
 	Mode     string 
 	ThreadCount int
+
+	// PoolIdleLimit caps the bytes of idle image buffers kept for reuse
+	// between images (see png.BufferPool); 0 means no cap other than
+	// MemoryBudget. It doesn't bound the buffers of images being processed,
+	// MemoryBudget does.
+	PoolIdleLimit int64
+
+	// EffectsFiles are the request files to process. By default the
+	// sequential and work stealing modes read ../data/effects.txt and map
+	// reduce maps ../data/effects1.txt and ../data/effects2.txt, one mapper
+	// per file.
+	EffectsFiles []string
+
+	// InDir and OutDir are the roots images are read from and written to,
+	// ../data/in and ../data/out by default.
+	InDir  string
+	OutDir string
+
+	// DecodeWorkers, EffectWorkers and EncodeWorkers size the pools of the
+	// pipeline mode ("pipe"), joined by queues of QueueDepth images. By
+	// default the effects pool has ThreadCount goroutines, the other two
+	// half as many rounded up, and the queues hold ThreadCount images.
+	DecodeWorkers, EffectWorkers, EncodeWorkers int
+	QueueDepth                                  int
+
+	// MemoryBudget, when positive, caps the bytes of the images being
+	// processed at once: before decoding, every task reserves what it is
+	// estimated to need from the PNG header and its effects (see
+	// png.MemoryEstimate) and waits until that much is free. Idle buffers
+	// kept for reuse between images count against it too and are dropped
+	// to make room; PoolIdleLimit bounds them further.
+	MemoryBudget int64
+	budget       *memoryBudget
+
+	// KeepGoing, when set, records a request that fails in Stats.Failures
+	// and goes on with the others instead of panicking.
+	KeepGoing bool
+	failures  *failureLog
+
+	// Trace, when set, records a span for loading, each effect and saving
+	// of every task, and the steals, on the track of the worker doing them.
+	Trace *Tracer
+}
+
+// withDefaults fills in the paths config leaves empty.
+func (config Config) withDefaults() Config {
+	if len(config.EffectsFiles) == 0 {
+		if config.Mode == "mr" {
+			config.EffectsFiles = []string{"../data/effects1.txt", "../data/effects2.txt"}
+		} else {
+			config.EffectsFiles = []string{"../data/effects.txt"}
+		}
+	}
+	if config.InDir == "" {
+		config.InDir = "../data/in"
+	}
+	if config.OutDir == "" {
+		config.OutDir = "../data/out"
+	}
+	threads := config.ThreadCount
+	if threads < 1 {
+		threads = 1
+	}
+	if config.EffectWorkers <= 0 {
+		config.EffectWorkers = threads
+	}
+	if config.DecodeWorkers <= 0 {
+		config.DecodeWorkers = (threads + 1) / 2
+	}
+	if config.EncodeWorkers <= 0 {
+		config.EncodeWorkers = (threads + 1) / 2
+	}
+	if config.QueueDepth <= 0 {
+		config.QueueDepth = threads
+	}
+	return config
 }
 
+// bind points request at the images of dataDir under config's roots.
+func (config Config) bind(request *Request, dataDir string) {
+	request.dataDir = dataDir
+	request.inDir = config.InDir
+	request.outDir = config.OutDir
+	request.trace = config.Trace
+	request.budget = config.budget
+	request.failures = config.failures
+}
+
+// MapReducer is a request from one of the map reduce effects files, which
+// also name the region the image belongs to.
 type MapReducer struct {
-	InPath  string   `json:"inPath"`
-	OutPath string   `json:"outPath"`
-	Effects []string `json:"effects"`
-	dataDir string
-	Region    string `json:"region"`
+	Request
+	Region string `json:"region"`
 }
 
-func RunWorkStealing(config Config) {
+func RunWorkStealing(config Config) []WorkerStats {
+	config = config.withDefaults()
 	numThreads := config.ThreadCount
-	pathToFile := "../data/effects.txt"
-	file, _ := os.Open(pathToFile)
-	reader := json.NewDecoder(file)
 	ws := NewWorkStealingExecutor(numThreads, 10)
 
-	for reader.More() {
-		var req Request
-		err := reader.Decode(&req)
+	for _, pathToFile := range config.EffectsFiles {
+		file, err := os.Open(pathToFile)
 		if err != nil {
 			panic(err)
 		}
-	
-		for _, dir := range strings.Split(config.DataDirs, "+") {
-			req.dataDir = dir
-			ws.Submit(req)
+		reader := json.NewDecoder(file)
+
+		for reader.More() {
+			var req Request
+			err := reader.Decode(&req)
+			if err != nil {
+				panic(err)
+			}
+
+			for _, dir := range strings.Split(config.DataDirs, "+") {
+				config.bind(&req, dir)
+				ws.Submit(req)
+			}
 		}
+		file.Close()
 	}
 	ws.Shutdown()
+	return ws.Stats()
 }
 
-func RunMapReduce(config Config) {
-	resultChannel := make(chan map[string][]MapReducer, 2)
-	go mapper(config, 1, resultChannel)
-	go mapper(config, 2, resultChannel)
+func RunMapReduce(config Config) []WorkerStats {
+	config = config.withDefaults()
+	resultChannel := make(chan map[string][]MapReducer, len(config.EffectsFiles))
+	for _, filePath := range config.EffectsFiles {
+		go mapper(config, filePath, resultChannel)
+	}
 
-	mapped := make([]map[string][]MapReducer, 2)
-	mapped[0] = <-resultChannel
-	mapped[1] = <-resultChannel
+	mapped := make([]map[string][]MapReducer, len(config.EffectsFiles))
+	for i := range mapped {
+		mapped[i] = <-resultChannel
+	}
 
-	shuffler(mapped, config)
+	return shuffler(mapped, config)
 }
 
-func Schedule(config Config) {
+// ValidMode reports whether mode names one of the schedulers of Schedule:
+// "s", "ws", "mr" or "pipe".
+func ValidMode(mode string) bool {
+	switch mode {
+	case "s", "ws", "mr", "pipe":
+		return true
+	}
+	return false
+}
+
+// Schedule processes the requests of config in its mode and returns what
+// each worker did.
+func Schedule(config Config) Stats {
+	png.DefaultPool.SetIdleLimit(config.PoolIdleLimit)
+	if err := os.MkdirAll(config.withDefaults().OutDir, 0o755); err != nil {
+		panic(err)
+	}
+	config.budget = newMemoryBudget(config.MemoryBudget, png.DefaultPool)
+	config.failures = newFailureLog(config.KeepGoing)
+	stats := Stats{Mode: config.Mode}
+	start := time.Now()
 	if config.Mode == "ws" {
-		RunWorkStealing(config)
+		stats.Workers = RunWorkStealing(config)
 	} else if config.Mode == "mr" {
-		RunMapReduce(config)
+		stats.Workers = RunMapReduce(config)
+	} else if config.Mode == "pipe" {
+		stats.Workers = RunPipeline(config)
 	} else {
-		RunSequential(config)
+		stats.Mode = "s"
+		stats.Workers = RunSequential(config)
 	}
+	stats.Wall = time.Since(start)
+	stats.MemoryPeak = config.budget.maxUsed()
+	stats.Failures = config.failures.list()
+	return stats
 }
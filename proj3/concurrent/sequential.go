@@ -1,10 +1,12 @@
 package concurrent
 
 import (
+	"errors"
 	"os"
 	"proj3/png"
 	"strings"
 	"encoding/json"
+	"time"
 )
 
 type Request struct {
@@ -12,36 +14,142 @@ type Request struct {
 	OutPath string   `json:"outPath"`
 	Effects []string `json:"effects"`
 	dataDir string
+
+	// Where the images of this request are read from and written to,
+	// filled in from the Config that scheduled it.
+	inDir, outDir string
+	trace         *Tracer
+	budget        *memoryBudget
+	failures      *failureLog
+
+	// LinearLight runs convolution and resampling effects on linear light
+	// values (see png.Image.LinearLight).
+	LinearLight bool `json:"linearLight"`
+
+	// Save tunes the encoder, which is picked from the extension of OutPath.
+	Save png.SaveOptions `json:"save"`
+
+	// StripRows, when set, processes the image that many rows at a time
+	// instead of loading it whole (see png.RunTiled). Requests whose
+	// effects or files can't be split fall back to loading the image.
+	StripRows int `json:"stripRows"`
 }
 
 
 
-func processImage(request Request, dataDir string) {
-	fileInpath := "../data/in/" + dataDir + "/" + request.InPath
-	fileOutpath := "../data/out/" + dataDir + "_" + request.OutPath
-	pngImg, err := png.Load(fileInpath)
+// processImage runs request on the image of dataDir as worker number worker.
+func processImage(request Request, dataDir string, worker int) {
+	request.dataDir = dataDir
+	defer request.failures.catch(request)
+	taskStart := time.Now()
+	defer func() {
+		_, out := request.paths()
+		request.trace.span(worker, "task", request.name(), taskStart, map[string]interface{}{"out": out})
+	}()
+	held := reserve(request, worker)
+	defer request.budget.release(held)
+	if runStrips(request, worker) {
+		return
+	}
+	pngImg := loadStage(request, worker)
+	effectsStage(pngImg, request, worker)
+	saveStage(pngImg, request, worker)
+}
+
+// The stages of a request, run one after the other by processImage and by
+// separate pools in the pipeline mode.
+
+// paths returns the files request reads and writes.
+func (request Request) paths() (in, out string) {
+	return request.inDir + "/" + request.dataDir + "/" + request.InPath,
+		request.outDir + "/" + request.dataDir + "_" + request.OutPath
+}
+
+// name identifies request in traces.
+func (request Request) name() string {
+	return request.dataDir + "/" + request.InPath
+}
+
+// runStrips processes request a strip at a time when it asks to, and
+// reports whether it did; loading, the effects and saving are then
+// interleaved.
+func runStrips(request Request, worker int) bool {
+	if request.StripRows <= 0 {
+		return false
+	}
+	start := time.Now()
+	in, out := request.paths()
+	err := png.RunTiled(in, out, request.Effects, request.StripRows, request.LinearLight, request.inDir, request.Save)
+	if err == nil {
+		request.trace.span(worker, "stage", "strips", start, map[string]interface{}{"task": request.name(), "effects": request.Effects})
+		return true
+	}
+	if !errors.Is(err, png.ErrNotTileable) {
+		panic(err)
+	}
+	return false
+}
+
+func loadStage(request Request, worker int) *png.Image {
+	start := time.Now()
+	in, _ := request.paths()
+	pngImg, err := png.Load(in)
 	if err != nil {
 		panic(err)
 	}
+	request.trace.span(worker, "stage", "load", start, map[string]interface{}{"task": request.name()})
+	return pngImg
+}
+
+func effectsStage(pngImg *png.Image, request Request, worker int) {
+	pngImg.LinearLight = request.LinearLight
+	pngImg.Dir = request.inDir
+	if trace := request.trace; trace != nil {
+		pngImg.EffectDone = func(effect string, start time.Time) {
+			trace.span(worker, "effect", effect, start, map[string]interface{}{"task": request.name()})
+		}
+	}
 	pngImg.RunEffects(request.Effects)
-	pngImg.Save(fileOutpath)
 }
 
-func RunSequential(config Config) {
-	dataDirs := strings.Split(config.DataDirs, "+")
-	effectsPathFile := "../data/effects.txt"
+func saveStage(pngImg *png.Image, request Request, worker int) {
+	start := time.Now()
+	_, out := request.paths()
+	err := pngImg.SaveWith(out, request.Save)
+	if err != nil {
+		panic(err)
+	}
+	request.trace.span(worker, "stage", "save", start, map[string]interface{}{"task": request.name()})
+	pngImg.Release()
+}
 
-	effectsFile, _ := os.Open(effectsPathFile)
-	reader := json.NewDecoder(effectsFile)
+func RunSequential(config Config) []WorkerStats {
+	config = config.withDefaults()
+	dataDirs := strings.Split(config.DataDirs, "+")
+	stats := WorkerStats{}
+	clock := startClock(&stats)
 
-	for reader.More() {
-		var request Request
-		err := reader.Decode(&request)
+	for _, effectsPathFile := range config.EffectsFiles {
+		effectsFile, err := os.Open(effectsPathFile)
 		if err != nil {
 			panic(err)
 		}
-		for _, dataDir := range dataDirs {
-			processImage(request, dataDir)
+		reader := json.NewDecoder(effectsFile)
+
+		for reader.More() {
+			var request Request
+			err := reader.Decode(&request)
+			if err != nil {
+				panic(err)
+			}
+			for _, dataDir := range dataDirs {
+				config.bind(&request, dataDir)
+				clock.busy(func() { processImage(request, dataDir, 0) })
+				stats.LocalTasks += 1
+			}
 		}
+		effectsFile.Close()
 	}
+	clock.stop()
+	return []WorkerStats{stats}
 }
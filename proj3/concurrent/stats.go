@@ -0,0 +1,128 @@
+package concurrent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// WorkerStats counts what one worker goroutine of a run did.
+type WorkerStats struct {
+	Worker int `json:"worker"`
+
+	// Stage is the pool of the worker in the pipeline mode: "decode",
+	// "effects" or "encode". Under work stealing, a row with Stage "submit"
+	// counts the tasks the submitting goroutine ran itself because every
+	// queue was full.
+	Stage string `json:"stage,omitempty"`
+
+	// LocalTasks are the tasks the worker took from its own queue: its
+	// deque under work stealing, those of the groups it reduced under map
+	// reduce, every task when running sequentially and the tasks its pool's
+	// stage was run on in the pipeline mode.
+	LocalTasks int `json:"localTasks"`
+
+	// Steals and FailedSteals count the PopTops on another worker's deque
+	// that took a task and that lost the race for it.
+	Steals       int `json:"steals"`
+	FailedSteals int `json:"failedSteals"`
+
+	// Busy is the time spent processing tasks and Idle the rest of the
+	// worker's life, spent looking for work.
+	Busy time.Duration `json:"busyNs"`
+	Idle time.Duration `json:"idleNs"`
+
+	// QueueHighWater is the most tasks the worker's queue held: its deque
+	// under work stealing, the shared channel of groups under map reduce
+	// and the queue feeding the worker's pool in the pipeline mode.
+	QueueHighWater int `json:"queueHighWater"`
+}
+
+// Tasks returns the number of tasks the worker processed.
+func (stats WorkerStats) Tasks() int {
+	return stats.LocalTasks + stats.Steals
+}
+
+// Stats describes a run of Schedule.
+type Stats struct {
+	Mode    string        `json:"mode"`
+	Wall    time.Duration `json:"wallNs"`
+	Workers []WorkerStats `json:"workers"`
+
+	// MemoryPeak is the most bytes reserved at once under
+	// Config.MemoryBudget, 0 without a budget.
+	MemoryPeak int64 `json:"memoryPeak,omitempty"`
+
+	// Failures are the errors of the requests that failed under
+	// Config.KeepGoing, each prefixed with the image of the request.
+	Failures []string `json:"failures,omitempty"`
+}
+
+// Tasks returns the number of tasks processed by all workers. In the
+// pipeline mode every task passes the effects pool, so only it counts.
+func (stats Stats) Tasks() int {
+	tasks := 0
+	for _, worker := range stats.Workers {
+		if worker.Stage != "decode" && worker.Stage != "encode" {
+			tasks += worker.Tasks()
+		}
+	}
+	return tasks
+}
+
+// WriteTable writes stats as a table with a row per worker.
+func (stats Stats) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "worker\tlocal\tsteals\tfailed\tbusy\tidle\tutil\tqueue max\t")
+	for _, worker := range stats.Workers {
+		util := 0.0
+		if life := worker.Busy + worker.Idle; life > 0 {
+			util = 100 * float64(worker.Busy) / float64(life)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\t%.0f%%\t%d\t\n", strings.TrimSpace(fmt.Sprint(worker.Stage, " ", worker.Worker)), worker.LocalTasks,
+			worker.Steals, worker.FailedSteals, worker.Busy.Round(time.Millisecond),
+			worker.Idle.Round(time.Millisecond), util, worker.QueueHighWater)
+	}
+	fmt.Fprintf(tw, "%s: %d tasks in %s", stats.Mode, stats.Tasks(), stats.Wall.Round(time.Millisecond))
+	if stats.MemoryPeak > 0 {
+		peak, unit := float64(stats.MemoryPeak)/(1<<20), "MiB"
+		if peak < 1 {
+			peak, unit = float64(stats.MemoryPeak)/(1<<10), "KiB"
+		}
+		fmt.Fprintf(tw, ", at most %.1f %s of images at once", peak, unit)
+	}
+	fmt.Fprintln(tw)
+	return tw.Flush()
+}
+
+// WriteJSON writes stats as indented JSON. Durations are in nanoseconds.
+func (stats Stats) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(stats)
+}
+
+// workerClock splits the life of a worker into busy and idle time.
+type workerClock struct {
+	start time.Time
+	stats *WorkerStats
+}
+
+func startClock(stats *WorkerStats) workerClock {
+	return workerClock{start: time.Now(), stats: stats}
+}
+
+// busy runs task and adds its duration to the busy time.
+func (clock workerClock) busy(task func()) {
+	start := time.Now()
+	task()
+	clock.stats.Busy += time.Since(start)
+}
+
+// stop sets the idle time to the part of the worker's life it wasn't busy.
+func (clock workerClock) stop() {
+	clock.stats.Idle = time.Since(clock.start) - clock.stats.Busy
+}
@@ -0,0 +1,68 @@
+package concurrent
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScheduleStats(t *testing.T) {
+	root := t.TempDir()
+	config := Config{
+		DataDirs:     "small+big",
+		ThreadCount:  3,
+		EffectsFiles: []string{writeDataset(t, root, "small", "big")},
+		InDir:        filepath.Join(root, "in"),
+		OutDir:       filepath.Join(root, "out"),
+	}
+	for _, mode := range []string{"s", "ws", "mr"} {
+		config.Mode = mode
+		stats := Schedule(config)
+		workers := config.ThreadCount
+		if mode == "s" {
+			workers = 1
+		}
+		if len(stats.Workers) != workers {
+			t.Errorf("%s: %d workers, want %d", mode, len(stats.Workers), workers)
+		}
+		if tasks := stats.Tasks(); tasks != 8 {
+			t.Errorf("%s: %d tasks, want 8", mode, tasks)
+		}
+		for i, worker := range stats.Workers {
+			if worker.Worker != i || worker.Busy < 0 || worker.Idle < 0 {
+				t.Errorf("%s: worker %d has %+v", mode, i, worker)
+			}
+			if worker.Tasks() > 0 && worker.Busy == 0 {
+				t.Errorf("%s: worker %d processed %d tasks in no time", mode, i, worker.Tasks())
+			}
+			if mode != "ws" && worker.Steals+worker.FailedSteals > 0 {
+				t.Errorf("%s: worker %d stole", mode, i)
+			}
+		}
+		if mode == "ws" {
+			// Submit deals the tasks round robin, so every deque held one.
+			for i, worker := range stats.Workers {
+				if worker.QueueHighWater < 1 {
+					t.Errorf("ws: worker %d never had a queued task", i)
+				}
+			}
+		}
+
+		var table, js bytes.Buffer
+		if err := stats.WriteTable(&table); err != nil {
+			t.Fatal(err)
+		}
+		if lines := strings.Count(table.String(), "\n"); lines != workers+2 {
+			t.Errorf("%s: table has %d lines, want %d:\n%s", mode, lines, workers+2, table.String())
+		}
+		if err := stats.WriteJSON(&js); err != nil {
+			t.Fatal(err)
+		}
+		var decoded Stats
+		if err := json.Unmarshal(js.Bytes(), &decoded); err != nil || decoded.Tasks() != 8 || decoded.Mode != mode {
+			t.Errorf("%s: JSON %s decodes to %+v, %v", mode, js.String(), decoded, err)
+		}
+	}
+}
@@ -9,6 +9,8 @@ import (
 type Exec interface {
 	Submit(task interface{})
 	Shutdown()
+	// Stats returns the counters of each worker; call it after Shutdown.
+	Stats() []WorkerStats
 }
 
 type WorkStealingExecutor struct {
@@ -18,6 +20,15 @@ type WorkStealingExecutor struct {
 	currIndex    int
 	totalTasks   int
 	localGoroutineQueues []BDEQueue
+	// bottomMtxs serialize Submit's pushes with the pops of each queue's
+	// worker, since a BDEQueue allows only one goroutine at its bottom.
+	bottomMtxs []sync.Mutex
+	// stats[i] is written by worker i, except for QueueHighWater, which
+	// Submit keeps under bottomMtxs[i].
+	stats []WorkerStats
+	// submitter counts the tasks Submit ran itself, as worker capacity.
+	submitter   WorkerStats
+	submitClock workerClock
 	mtx          *sync.Mutex
 }
 
@@ -34,12 +45,20 @@ func NewWorkStealingExecutor(capacity, threshold int) Exec {
 		shutdown:     new(bool),
 		totalTasks:   0,
 		localGoroutineQueues: taskQueues,
+		bottomMtxs:   make([]sync.Mutex, capacity),
+		stats:        make([]WorkerStats, capacity),
 		mtx:          &sync.Mutex{},
 	}
+	executor.startSubmitter()
 	executor.start()
 	return executor
 }
 
+func (w *WorkStealingExecutor) startSubmitter() {
+	w.submitter = WorkerStats{Worker: w.capacity, Stage: "submit"}
+	w.submitClock = startClock(&w.submitter)
+}
+
 func (w *WorkStealingExecutor) start() {
 	for i := 0; i < w.capacity; i += 1 {
 		w.wg.Add(1)
@@ -49,10 +68,15 @@ func (w *WorkStealingExecutor) start() {
 
 func stealingWorker(w *WorkStealingExecutor, threadIdx int) {
 	defer w.wg.Done()
+	stats := &w.stats[threadIdx]
+	stats.Worker = threadIdx
+	clock := startClock(stats)
+	defer clock.stop()
 	// Loop until shutdown is true and all queues are empty
 	for {
 		if w.localGoroutineQueues[threadIdx].IsEmpty() {
-
+			// With a single worker there is nobody to steal from.
+			if w.capacity > 1 {
 				rand.Seed(time.Now().UnixNano())
 				hostThread := threadIdx
 				for hostThread == threadIdx {
@@ -60,24 +84,32 @@ func stealingWorker(w *WorkStealingExecutor, threadIdx int) {
 				}
 
 				if w.localGoroutineQueues[hostThread].Size() > 2 {
-					// steal
-					currTask, _ := (w.localGoroutineQueues[hostThread].PopTop()).(Request)
-					processImage(currTask, currTask.dataDir)
-					w.mtx.Lock()
-					w.totalTasks -= 1
-					w.mtx.Unlock()
+					// steal; the pop fails (nil) when the owner or another
+					// thief got there first, and then nothing was taken.
+					if currTask, ok := (w.localGoroutineQueues[hostThread].PopTop()).(Request); ok {
+						stats.Steals += 1
+						currTask.trace.instant(threadIdx, "steal", "steal", map[string]interface{}{"from": hostThread, "task": currTask.dataDir + "/" + currTask.InPath})
+						clock.busy(func() { processImage(currTask, currTask.dataDir, threadIdx) })
+						w.mtx.Lock()
+						w.totalTasks -= 1
+						w.mtx.Unlock()
+					} else {
+						stats.FailedSteals += 1
+					}
 				}
-		
-		} else {
-			if w.localGoroutineQueues[threadIdx].IsEmpty() {
-				continue
 			}
+		} else {
 			// pop from bottom of self queue
-			currTask, _ := (w.localGoroutineQueues[threadIdx].PopBottom()).(Request)
-			processImage(currTask, currTask.dataDir)
-			w.mtx.Lock()
-			w.totalTasks -= 1
-			w.mtx.Unlock()
+			w.bottomMtxs[threadIdx].Lock()
+			task := w.localGoroutineQueues[threadIdx].PopBottom()
+			w.bottomMtxs[threadIdx].Unlock()
+			if currTask, ok := task.(Request); ok {
+				stats.LocalTasks += 1
+				clock.busy(func() { processImage(currTask, currTask.dataDir, threadIdx) })
+				w.mtx.Lock()
+				w.totalTasks -= 1
+				w.mtx.Unlock()
+			}
 		}
 
 		w.mtx.Lock()
@@ -91,17 +123,48 @@ func stealingWorker(w *WorkStealingExecutor, threadIdx int) {
 }
 
 func (w *WorkStealingExecutor) Shutdown() {
+	w.submitClock.stop()
 	w.mtx.Lock()
 	*w.shutdown = true
 	w.mtx.Unlock()
 	w.wg.Wait()
 }
 
+// Submit queues task on the workers in turn. A full queue passes the task
+// on to the next one, and when every queue is full the caller runs the task
+// itself rather than drop it. Those tasks are counted and traced as the
+// "submit" worker, numbered after the last.
 func (w *WorkStealingExecutor) Submit(task interface{}) {
 	w.mtx.Lock()
-	w.localGoroutineQueues[w.currIndex].PushBottom(task)
-	w.totalTasks += 1
-	w.currIndex += 1
-	w.currIndex = w.currIndex % w.capacity
+	for i := 0; i < w.capacity; i += 1 {
+		idx := (w.currIndex + i) % w.capacity
+		w.bottomMtxs[idx].Lock()
+		queue := w.localGoroutineQueues[idx]
+		err := queue.PushBottom(task)
+		if size := queue.Size(); err == nil && size > w.stats[idx].QueueHighWater {
+			w.stats[idx].QueueHighWater = size
+		}
+		w.bottomMtxs[idx].Unlock()
+		if err == nil {
+			w.totalTasks += 1
+			w.currIndex = (idx + 1) % w.capacity
+			w.mtx.Unlock()
+			return
+		}
+	}
 	w.mtx.Unlock()
-}
\ No newline at end of file
+	if currTask, ok := task.(Request); ok {
+		currTask.trace.name(w.capacity, "submit")
+		w.submitter.LocalTasks += 1
+		w.submitClock.busy(func() { processImage(currTask, currTask.dataDir, w.capacity) })
+	}
+}
+
+// Stats returns a row per worker, and one for Submit if it ran any tasks.
+func (w *WorkStealingExecutor) Stats() []WorkerStats {
+	stats := append([]WorkerStats(nil), w.stats...)
+	if w.submitter.LocalTasks > 0 {
+		stats = append(stats, w.submitter)
+	}
+	return stats
+}
@@ -0,0 +1,75 @@
+package concurrent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// idleExecutor returns an executor over queues of queueCapacity whose
+// workers are not started, so Submit's placement can be inspected.
+func idleExecutor(workers, queueCapacity int) *WorkStealingExecutor {
+	queues := make([]BDEQueue, workers)
+	for i := range queues {
+		queues[i] = NewBoundedDEQueue(queueCapacity)
+	}
+	w := &WorkStealingExecutor{
+		wg:                   &sync.WaitGroup{},
+		capacity:             workers,
+		shutdown:             new(bool),
+		localGoroutineQueues: queues,
+		bottomMtxs:           make([]sync.Mutex, workers),
+		stats:                make([]WorkerStats, workers),
+		mtx:                  &sync.Mutex{},
+	}
+	w.startSubmitter()
+	return w
+}
+
+func TestSubmitFullQueues(t *testing.T) {
+	root := t.TempDir()
+	writeDataset(t, root, "small")
+	config := Config{InDir: filepath.Join(root, "in"), OutDir: filepath.Join(root, "out"), Trace: NewTracer()}
+	if err := os.MkdirAll(config.OutDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	request := func(out string) Request {
+		r := Request{InPath: "0.png", OutPath: out, Effects: []string{"G"}}
+		config.bind(&r, "small")
+		return r
+	}
+
+	w := idleExecutor(2, 2)
+	// Fill the first queue; the next tasks spill over to the second.
+	w.localGoroutineQueues[0].PushBottom(request("pre0.png"))
+	w.localGoroutineQueues[0].PushBottom(request("pre1.png"))
+	w.Submit(request("a.png"))
+	w.Submit(request("b.png"))
+	if size := w.localGoroutineQueues[1].Size(); size != 2 || w.totalTasks != 2 {
+		t.Fatalf("second queue holds %d tasks, %d counted; want 2 and 2", size, w.totalTasks)
+	}
+
+	// With every queue full the task runs at once instead of being lost.
+	w.Submit(request("c.png"))
+	if w.totalTasks != 2 {
+		t.Errorf("a task run by Submit was counted as queued: %d", w.totalTasks)
+	}
+	if _, err := os.Stat(filepath.Join(config.OutDir, "small_c.png")); err != nil {
+		t.Errorf("task on full queues was not run: %v", err)
+	}
+	// It is counted and traced on a row of its own after the workers.
+	stats := w.Stats()
+	if len(stats) != 3 || stats[2].Stage != "submit" || stats[2].Worker != 2 || stats[2].LocalTasks != 1 || stats[2].Busy <= 0 {
+		t.Errorf("stats %+v, want a submit row with 1 task after the 2 workers", stats)
+	}
+	var trace bytes.Buffer
+	if _, err := config.Trace.WriteTo(&trace); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(trace.String(), `"tid":2,"args":{"name":"submit"}`) {
+		t.Errorf("trace has no submit track: %s", trace.String())
+	}
+}
@@ -0,0 +1,130 @@
+package concurrent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tracer records what the workers of a run did and when, as events in the
+// Chrome trace-event format, which chrome://tracing and ui.perfetto.dev
+// show as a timeline with a track per worker. A nil *Tracer records
+// nothing.
+type Tracer struct {
+	mtx    sync.Mutex
+	start  time.Time
+	events []traceEvent
+	names  map[int]string // track names other than "worker N"
+}
+
+// traceEvent is one entry of the traceEvents array. Times are in
+// microseconds since the tracer was made.
+type traceEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Phase string                 `json:"ph"`
+	Ts    float64                `json:"ts"`
+	Dur   *float64               `json:"dur,omitempty"`
+	Pid   int                    `json:"pid"`
+	Tid   int                    `json:"tid"`
+	Scope string                 `json:"s,omitempty"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+func NewTracer() *Tracer {
+	return &Tracer{start: time.Now()}
+}
+
+func (tracer *Tracer) micros(t time.Time) float64 {
+	return float64(t.Sub(tracer.start).Nanoseconds()) / 1e3
+}
+
+func (tracer *Tracer) add(event traceEvent) {
+	tracer.mtx.Lock()
+	tracer.events = append(tracer.events, event)
+	tracer.mtx.Unlock()
+}
+
+// name names the track of worker, which is "worker N" otherwise.
+func (tracer *Tracer) name(worker int, name string) {
+	if tracer == nil {
+		return
+	}
+	tracer.mtx.Lock()
+	if tracer.names == nil {
+		tracer.names = map[int]string{}
+	}
+	tracer.names[worker] = name
+	tracer.mtx.Unlock()
+}
+
+// span records that worker spent the time from start until now on name.
+func (tracer *Tracer) span(worker int, cat, name string, start time.Time, args map[string]interface{}) {
+	if tracer == nil {
+		return
+	}
+	dur := tracer.micros(time.Now()) - tracer.micros(start)
+	tracer.add(traceEvent{Name: name, Cat: cat, Phase: "X", Ts: tracer.micros(start), Dur: &dur, Tid: worker, Args: args})
+}
+
+// instant records that name happened on worker now.
+func (tracer *Tracer) instant(worker int, cat, name string, args map[string]interface{}) {
+	if tracer == nil {
+		return
+	}
+	tracer.add(traceEvent{Name: name, Cat: cat, Phase: "i", Ts: tracer.micros(time.Now()), Tid: worker, Scope: "t", Args: args})
+}
+
+// WriteTo writes the events recorded so far as a trace-event JSON object,
+// naming each worker's track.
+func (tracer *Tracer) WriteTo(w io.Writer) (int64, error) {
+	tracer.mtx.Lock()
+	events := append([]traceEvent(nil), tracer.events...)
+	trackNames := map[int]string{}
+	for worker, name := range tracer.names {
+		trackNames[worker] = name
+	}
+	tracer.mtx.Unlock()
+
+	workers := map[int]bool{}
+	for _, event := range events {
+		workers[event.Tid] = true
+	}
+	var names []traceEvent
+	for worker := range workers {
+		name, ok := trackNames[worker]
+		if !ok {
+			name = fmt.Sprintf("worker %d", worker)
+		}
+		names = append(names, traceEvent{Name: "thread_name", Phase: "M", Tid: worker,
+			Args: map[string]interface{}{"name": name}})
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].Tid < names[j].Tid })
+
+	data, err := json.Marshal(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{append(names, events...), "ms"})
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// WriteFile writes the trace to path.
+func (tracer *Tracer) WriteFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := tracer.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
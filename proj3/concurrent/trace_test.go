@@ -0,0 +1,90 @@
+package concurrent
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	root := t.TempDir()
+	manifest := writeDataset(t, root, "small")
+	for _, mode := range []string{"s", "ws", "mr"} {
+		tracer := NewTracer()
+		Schedule(Config{
+			DataDirs:     "small",
+			Mode:         mode,
+			ThreadCount:  2,
+			EffectsFiles: []string{manifest},
+			InDir:        filepath.Join(root, "in"),
+			OutDir:       filepath.Join(root, "out"),
+			Trace:        tracer,
+		})
+		var buf bytes.Buffer
+		if _, err := tracer.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		var trace struct {
+			TraceEvents []struct {
+				Name string
+				Cat  string
+				Ph   string
+				Ts   float64
+				Dur  float64
+				Tid  int
+			}
+		}
+		if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+
+		count := map[string]int{}
+		tasks := map[int][][2]float64{}
+		for _, event := range trace.TraceEvents {
+			if event.Ph == "X" {
+				count[event.Cat+" "+event.Name]++
+				if event.Dur < 0 {
+					t.Errorf("%s: %s has negative duration", mode, event.Name)
+				}
+				if event.Cat == "task" {
+					tasks[event.Tid] = append(tasks[event.Tid], [2]float64{event.Ts, event.Ts + event.Dur})
+				}
+			}
+			if event.Tid < 0 || event.Tid >= 2 {
+				t.Errorf("%s: event %s on worker %d", mode, event.Name, event.Tid)
+			}
+		}
+		// One request runs in strips, the other three load, run their
+		// effects and save.
+		want := map[string]int{
+			"task small/0.png": 2, "task small/1.png": 1, "task small/2.png": 1,
+			"stage strips": 1, "stage load": 3, "stage save": 3,
+			"effect G": 1, "effect S": 1, "effect B": 1,
+			"effect resize:w=11": 1, "effect hue:deg=30": 1,
+		}
+		for name, n := range want {
+			if count[name] != n {
+				t.Errorf("%s: %d %q spans, want %d", mode, count[name], name, n)
+			}
+		}
+
+		// Every stage lies within a task of the same worker.
+		for _, event := range trace.TraceEvents {
+			if event.Ph != "X" || event.Cat == "task" {
+				continue
+			}
+			inside := false
+			for _, task := range tasks[event.Tid] {
+				inside = inside || task[0] <= event.Ts && event.Ts+event.Dur <= task[1]
+			}
+			if !inside {
+				t.Errorf("%s: %s %s on worker %d is outside its tasks", mode, event.Cat, event.Name, event.Tid)
+			}
+		}
+		if !strings.Contains(buf.String(), `"thread_name"`) {
+			t.Errorf("%s: workers' tracks are not named", mode)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package concurrent
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// VerifyReport lists how the outputs of each mode differ from those of the
+// first mode Verify ran, by path relative to the mode's output root.
+type VerifyReport struct {
+	Modes     []string
+	Reference []string // files the first mode wrote
+
+	Missing   map[string][]string // files of the first mode a mode didn't write
+	Extra     map[string][]string // files a mode wrote that the first didn't
+	Differing map[string][]string // files whose bytes differ
+	Failed    map[string][]string // errors of the requests, or the run, that failed
+}
+
+// OK reports whether every mode ran without failures and wrote exactly the
+// same files as the first.
+func (report VerifyReport) OK() bool {
+	for _, mode := range report.Modes {
+		if len(report.Failed[mode]) > 0 {
+			return false
+		}
+		if len(report.Missing[mode])+len(report.Extra[mode])+len(report.Differing[mode]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify runs config under each of modes, writing the outputs of mode m to
+// outRoot/m (which is emptied first), and compares the files every mode
+// wrote with those of the first. All modes read the same effects files:
+// config.EffectsFiles, or ../data/effects.txt when it is empty. A request
+// that fails, or a run that fails as a whole, is listed in the report's
+// Failed and the other requests and modes still run. Verify itself fails
+// on an unknown or repeated mode, a thread count below 1, an effects file
+// that can't be read, or when the first mode wrote nothing, as there is
+// nothing to compare then.
+func Verify(config Config, modes []string, outRoot string) (VerifyReport, error) {
+	if config.ThreadCount < 1 {
+		return VerifyReport{}, fmt.Errorf("verify: need at least 1 thread, got %d", config.ThreadCount)
+	}
+	if len(modes) == 0 {
+		return VerifyReport{}, fmt.Errorf("verify: no modes to compare")
+	}
+	seen := map[string]bool{}
+	for _, mode := range modes {
+		if !ValidMode(mode) {
+			return VerifyReport{}, fmt.Errorf("verify: unknown mode %q", mode)
+		}
+		if seen[mode] {
+			return VerifyReport{}, fmt.Errorf("verify: mode %s given twice", mode)
+		}
+		seen[mode] = true
+	}
+	if len(config.EffectsFiles) == 0 {
+		config.EffectsFiles = []string{"../data/effects.txt"}
+	}
+	for _, path := range config.EffectsFiles {
+		file, err := os.Open(path)
+		if err != nil {
+			return VerifyReport{}, err
+		}
+		file.Close()
+	}
+	failed := map[string][]string{}
+	for _, mode := range modes {
+		dir := filepath.Join(outRoot, mode)
+		if err := os.RemoveAll(dir); err != nil {
+			return VerifyReport{}, err
+		}
+		run := config
+		run.Mode = mode
+		run.OutDir = dir
+		run.KeepGoing = true
+		if failures := scheduleRecovered(run); len(failures) > 0 {
+			failed[mode] = failures
+		}
+	}
+	report, err := compareOutputs(modes, outRoot)
+	report.Failed = failed
+	if err == nil && len(report.Reference) == 0 && len(failed[modes[0]]) == 0 {
+		err = fmt.Errorf("verify: mode %s wrote no outputs", modes[0])
+	}
+	return report, err
+}
+
+// scheduleRecovered runs Schedule(config) and returns its failures, with a
+// panic of the run itself, such as an effects file that isn't valid JSON,
+// as the last.
+func scheduleRecovered(config Config) (failures []string) {
+	defer func() {
+		if r := recover(); r != nil {
+			failures = append(failures, fmt.Sprintf("run: %v", r))
+		}
+	}()
+	return Schedule(config).Failures
+}
+
+// compareOutputs compares the files under outRoot/m for each of modes with
+// those under the directory of the first mode.
+func compareOutputs(modes []string, outRoot string) (VerifyReport, error) {
+	report := VerifyReport{
+		Modes:     modes,
+		Missing:   map[string][]string{},
+		Extra:     map[string][]string{},
+		Differing: map[string][]string{},
+	}
+	refDir := filepath.Join(outRoot, modes[0])
+	ref, err := listFiles(refDir)
+	if err != nil {
+		return report, err
+	}
+	report.Reference = ref
+	for _, mode := range modes[1:] {
+		dir := filepath.Join(outRoot, mode)
+		files, err := listFiles(dir)
+		if err != nil {
+			return report, err
+		}
+		have := map[string]bool{}
+		for _, f := range files {
+			have[f] = true
+		}
+		for _, f := range ref {
+			if !have[f] {
+				report.Missing[mode] = append(report.Missing[mode], f)
+				continue
+			}
+			delete(have, f)
+			same, err := sameBytes(filepath.Join(refDir, f), filepath.Join(dir, f))
+			if err != nil {
+				return report, err
+			}
+			if !same {
+				report.Differing[mode] = append(report.Differing[mode], f)
+			}
+		}
+		for f := range have {
+			report.Extra[mode] = append(report.Extra[mode], f)
+		}
+		sort.Strings(report.Extra[mode])
+	}
+	return report, nil
+}
+
+// listFiles returns the sorted paths of the regular files under dir,
+// relative to it.
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, rel)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+func sameBytes(a, b string) (bool, error) {
+	da, err := os.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	db, err := os.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(da, db), nil
+}
@@ -0,0 +1,198 @@
+package concurrent
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	stdpng "image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeDataset fills root/in/<dir> with a few synthetic PNGs and returns the
+// path of a manifest of requests on them.
+func writeDataset(t *testing.T, root string, dirs ...string) string {
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, "in", dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			m := image.NewNRGBA(image.Rect(0, 0, 24+i*5, 17+i*3))
+			for y := 0; y < m.Rect.Dy(); y++ {
+				for x := 0; x < m.Rect.Dx(); x++ {
+					m.SetNRGBA(x, y, color.NRGBA{uint8(x * 10), uint8(y * 14), uint8(x*y + len(dir)), 255})
+				}
+			}
+			f, err := os.Create(filepath.Join(root, "in", dir, fmt.Sprintf("%d.png", i)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := stdpng.Encode(f, m); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+		}
+	}
+	manifest := filepath.Join(root, "effects.txt")
+	requests := `{"inPath": "0.png", "outPath": "0_gsb.png", "effects": ["G", "S", "B"]}
+{"inPath": "1.png", "outPath": "1_e.png", "effects": ["E"], "stripRows": 4}
+{"inPath": "2.png", "outPath": "2_resize.png", "effects": ["resize:w=11", "hue:deg=30"]}
+{"inPath": "0.png", "outPath": "0_copy.png", "effects": []}
+`
+	if err := os.WriteFile(manifest, []byte(requests), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+func TestVerifyModesAgree(t *testing.T) {
+	root := t.TempDir()
+	config := Config{
+		DataDirs:     "small+big",
+		ThreadCount:  3,
+		EffectsFiles: []string{writeDataset(t, root, "small", "big")},
+		InDir:        filepath.Join(root, "in"),
+	}
+	report, err := Verify(config, []string{"s", "ws", "mr", "pipe"}, filepath.Join(root, "verify"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Reference) != 8 {
+		t.Errorf("sequential mode wrote %v, want 8 files", report.Reference)
+	}
+	if !report.OK() {
+		t.Errorf("modes disagree: missing %v, extra %v, differing %v", report.Missing, report.Extra, report.Differing)
+	}
+}
+
+func TestVerifyReportsDifferences(t *testing.T) {
+	root := t.TempDir()
+	config := Config{
+		DataDirs:     "small",
+		ThreadCount:  2,
+		EffectsFiles: []string{writeDataset(t, root, "small")},
+		InDir:        filepath.Join(root, "in"),
+	}
+	out := filepath.Join(root, "verify")
+	if _, err := Verify(config, []string{"s", "ws"}, out); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tamper with the outputs of one mode and compare again without
+	// rerunning it.
+	ws := filepath.Join(out, "ws")
+	if err := os.Remove(filepath.Join(ws, "small_1_e.png")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "stray.png"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(ws, "small_0_gsb.png"), []byte("not a png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := compareOutputs([]string{"s", "ws"}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() {
+		t.Fatal("tampered outputs reported identical")
+	}
+	check := func(kind string, got []string, want string) {
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("%s %v, want [%s]", kind, got, want)
+		}
+	}
+	check("missing", report.Missing["ws"], "small_1_e.png")
+	check("extra", report.Extra["ws"], "stray.png")
+	check("differing", report.Differing["ws"], "small_0_gsb.png")
+}
+
+func TestVerifyNothingToCompare(t *testing.T) {
+	root := t.TempDir()
+	config := Config{
+		DataDirs:     "small",
+		ThreadCount:  2,
+		EffectsFiles: []string{filepath.Join(root, "missing.txt")},
+		InDir:        filepath.Join(root, "in"),
+	}
+	if _, err := Verify(config, []string{"s", "ws"}, filepath.Join(root, "verify")); !os.IsNotExist(err) {
+		t.Errorf("missing effects file: got %v, want a not-exist error", err)
+	}
+
+	// An effects file with no requests leaves nothing to compare.
+	config.EffectsFiles = []string{filepath.Join(root, "empty.txt")}
+	if err := os.WriteFile(config.EffectsFiles[0], nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(config, []string{"s", "ws"}, filepath.Join(root, "verify")); err == nil {
+		t.Error("expected an error when no outputs were written")
+	}
+}
+
+func TestVerifyBadArguments(t *testing.T) {
+	root := t.TempDir()
+	config := Config{
+		DataDirs:     "small",
+		ThreadCount:  2,
+		EffectsFiles: []string{writeDataset(t, root, "small")},
+		InDir:        filepath.Join(root, "in"),
+	}
+	out := filepath.Join(root, "verify")
+	for _, modes := range [][]string{nil, {"s", "sw"}, {"s", "ws", "s"}} {
+		if _, err := Verify(config, modes, out); err == nil {
+			t.Errorf("modes %q: no error", modes)
+		}
+	}
+	config.ThreadCount = 0
+	if _, err := Verify(config, []string{"s", "mr"}, out); err == nil {
+		t.Error("0 threads: no error")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("ran before rejecting the arguments: %v", err)
+	}
+}
+
+func TestVerifyFailedRequests(t *testing.T) {
+	root := t.TempDir()
+	manifest := writeDataset(t, root, "small")
+	good, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := `{"inPath": "missing.png", "outPath": "missing.png", "effects": ["G"]}
+{"inPath": "1.png", "outPath": "1_bad.png", "effects": ["resize:w=-1"]}
+`
+	if err := os.WriteFile(manifest, append([]byte(bad), good...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{
+		DataDirs:     "small",
+		ThreadCount:  2,
+		EffectsFiles: []string{manifest},
+		InDir:        filepath.Join(root, "in"),
+		// Tasks run one at a time, so one that keeps its memory after
+		// failing blocks the rest.
+		MemoryBudget: 1,
+	}
+	modes := []string{"s", "ws", "mr", "pipe"}
+	report, err := Verify(config, modes, filepath.Join(root, "verify"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() {
+		t.Error("failed requests reported OK")
+	}
+	if len(report.Reference) != 4 {
+		t.Errorf("sequential mode wrote %v, want the 4 good outputs", report.Reference)
+	}
+	for _, mode := range modes {
+		if got := report.Failed[mode]; len(got) != 2 {
+			t.Errorf("%s: failures %q, want 2", mode, got)
+		}
+		if n := len(report.Missing[mode]) + len(report.Extra[mode]) + len(report.Differing[mode]); n > 0 {
+			t.Errorf("%s: the good outputs differ", mode)
+		}
+	}
+}
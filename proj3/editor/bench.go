@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"proj3/benchmark"
+	"proj3/concurrent"
+	"strconv"
+	"strings"
+)
+
+const benchUsage = "Usage: editor bench [-modes ws,mr,pipe] [-datasets small,big,mixture] [-threads 2,4,6,8,12] [-n 5] [-o dir]\n" +
+	"Times every mode, dataset and thread count n times, with each dataset also run sequentially as\n" +
+	"the reference, and writes results.csv, results.json and a speedup-<mode>.svg plot per mode to\n" +
+	"dir (default ../benchmark/results).\n"
+
+// runBench implements "editor bench" and returns the exit status.
+func runBench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, benchUsage) }
+	modes := flags.String("modes", "ws,mr,pipe", "")
+	datasets := flags.String("datasets", "small,big,mixture", "")
+	threadList := flags.String("threads", "2,4,6,8,12", "")
+	repeat := flags.Int("n", 5, "")
+	outDir := flags.String("o", "../benchmark/results", "")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 || *repeat < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	// Check the modes before anything runs; Schedule would run an unknown
+	// one sequentially.
+	modeList := strings.Split(*modes, ",")
+	for _, mode := range modeList {
+		if !concurrent.ValidMode(mode) || mode == "s" {
+			fmt.Fprintf(os.Stderr, "bad mode %q: want ws, mr or pipe (s always runs as the reference)\n", mode)
+			return 2
+		}
+	}
+	var threads []int
+	for _, field := range strings.Split(*threadList, ",") {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "bad thread count %q\n", field)
+			return 2
+		}
+		threads = append(threads, n)
+	}
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	matrix := benchmark.Matrix{
+		Modes:    modeList,
+		Datasets: strings.Split(*datasets, ","),
+		Threads:  threads,
+		Repeat:   *repeat,
+		Base:     concurrent.Config{},
+	}
+	results := benchmark.Run(matrix, func(result benchmark.Result) {
+		fmt.Printf("%-3s %-8s %2d threads  %7.3fs ± %.3fs  speedup %.2f\n",
+			result.Mode, result.Dataset, result.Threads, result.Mean, result.Stddev, result.Speedup)
+	})
+
+	err := writeFile(filepath.Join(*outDir, "results.csv"), func(f *os.File) error { return benchmark.WriteCSV(f, results) })
+	if err == nil {
+		err = writeFile(filepath.Join(*outDir, "results.json"), func(f *os.File) error { return benchmark.WriteJSON(f, results) })
+	}
+	for _, mode := range matrix.Modes {
+		if err == nil {
+			err = writeFile(filepath.Join(*outDir, "speedup-"+mode+".svg"), func(f *os.File) error {
+				return benchmark.WriteSpeedupSVG(f, results, mode)
+			})
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
+
+func writeFile(path string, write func(*os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
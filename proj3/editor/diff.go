@@ -0,0 +1,100 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	stdpng "image/png"
+	"os"
+	"proj3/metrics"
+
+	// Registers the decoders for every format the editor reads.
+	_ "proj3/png"
+)
+
+const diffUsage = "Usage: editor diff [-o heatmap.png] [-scale N] a.png b.png\n" +
+	"Prints MSE, PSNR, SSIM, MS-SSIM and the largest channel difference between two images\n" +
+	"and writes a heatmap of where they differ.\n" +
+	"-o     = Where to write the heatmap (default diff.png, \"\" for none).\n" +
+	"-scale = 16-bit difference shown as white in the heatmap (default: the largest difference).\n"
+
+// runDiff implements "editor diff" and returns the exit status: 0 when the
+// images are identical, 1 when they differ and 2 on errors.
+func runDiff(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, diffUsage) }
+	heatmapPath := flags.String("o", "diff.png", "")
+	scale := flags.Uint("scale", 0, "")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	a, err := decodeFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	b, err := decodeFile(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	report, err := metrics.Compare(a, b)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Printf("MSE          %.6g\n", report.MSE)
+	fmt.Printf("PSNR         %.2f dB\n", report.PSNR)
+	fmt.Printf("SSIM         %.6f\n", report.SSIM)
+	fmt.Printf("MS-SSIM      %.6f\n", report.MSSSIM)
+	fmt.Printf("max abs diff %d\n", report.MaxAbsDiff)
+
+	if *heatmapPath != "" {
+		if *scale > 0xffff {
+			*scale = 0xffff
+		}
+		heatmap, err := metrics.Heatmap(a, b, uint16(*scale))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if err := writePNG(*heatmapPath, heatmap); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if report.MaxAbsDiff != 0 {
+		return 1
+	}
+	return 0
+}
+
+func decodeFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return m, nil
+}
+
+func writePNG(path string, m image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := stdpng.Encode(f, m); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"proj3/concurrent"
@@ -8,32 +9,91 @@ import (
 	"time"
 )
 
-const usage = "Usage: editor data_dir mode [number of threads]\n" +
+const usage = "Usage: editor [-pool-idle MiB] [-memory MiB] [-stats table|json] [-trace out.json] [-decode N] [-encode N] [-queue N] data_dir mode [number of threads]\n" +
+	"       editor diff [-o heatmap.png] a.png b.png\n" +
+	"       editor verify [-modes s,ws,mr,pipe] [-threads N] data_dir [effects.txt ...]\n" +
+	"       editor bench [-modes ws,mr,pipe] [-datasets small,big,mixture] [-threads 2,4,6,8,12] [-n 5] [-o dir]\n" +
 	"data_dir = The data directory to use to load the images.\n" +
-	"mode     = (s) run sequentially, (parfiles) process multiple files in parallel, (parslices) process slices of each image in parallel \n" +
-	"[number of threads] = Runs the parallel version of the program with the specified number of threads.\n"
+	"mode     = (s) run sequentially, (ws) work stealing, (mr) map reduce, (pipe) separate decode, effects and encode pools\n" +
+	"[number of threads] = Runs the parallel version of the program with the specified number of threads.\n" +
+	"-decode, -encode = Goroutines decoding and encoding in pipe mode, which runs the effects on [number of threads] (default half of it).\n" +
+	"-queue           = Images each pipe mode queue holds (default [number of threads]).\n" +
+	"-pool-idle  = Most MiB of idle image buffers kept for reuse between images (0 for no limit); -memory bounds the ones in use.\n" +
+	"-memory     = Most MiB of images processed at once; tasks wait for room before decoding (0 for no limit).\n" +
+	"-stats      = Print what each worker did (tasks, steals, busy and idle time) to stderr as a table or JSON.\n" +
+	"-trace      = Write a timeline of every worker's loads, effects, saves and steals in Chrome trace-event format.\n"
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println(usage)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		case "verify":
+			os.Exit(runVerify(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		}
+	}
+
+	poolIdle := flag.Int64("pool-idle", 0, "")
+	memoryBudget := flag.Int64("memory", 0, "")
+	statsFormat := flag.String("stats", "", "")
+	tracePath := flag.String("trace", "", "")
+	decoders := flag.Int("decode", 0, "")
+	encoders := flag.Int("encode", 0, "")
+	queueDepth := flag.Int("queue", 0, "")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	args := flag.Args()
+	if *statsFormat != "" && *statsFormat != "table" && *statsFormat != "json" {
+		fmt.Fprintf(os.Stderr, "-stats must be table or json, got %q\n", *statsFormat)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if len(args) < 1 {
+		fmt.Print(usage)
 		return
 	}
 	config := concurrent.Config{DataDirs: "", Mode: "", ThreadCount: 0}
-	config.DataDirs = os.Args[1]
+	config.DataDirs = args[0]
+	config.PoolIdleLimit = *poolIdle << 20
+	config.MemoryBudget = *memoryBudget << 20
+	config.DecodeWorkers = *decoders
+	config.EncodeWorkers = *encoders
+	config.QueueDepth = *queueDepth
 
-	if len(os.Args) >= 3 {
-		config.Mode = os.Args[2]
+	if len(args) >= 2 {
+		config.Mode = args[1]
 		threads := 1
-		if len(os.Args) > 3 {
-			threads, _ = strconv.Atoi(os.Args[3])
+		if len(args) > 2 {
+			threads, _ = strconv.Atoi(args[2])
 		}
 		config.ThreadCount = threads
 	} else {
 		config.Mode = "s"
 	}
 	
+	if *tracePath != "" {
+		config.Trace = concurrent.NewTracer()
+	}
+
 	start := time.Now()
-	concurrent.Schedule(config)
+	stats := concurrent.Schedule(config)
 	end := time.Since(start).Seconds()
 	fmt.Printf("%.2f\n", end)
+
+	switch *statsFormat {
+	case "":
+	case "json":
+		stats.WriteJSON(os.Stderr)
+	case "table":
+		stats.WriteTable(os.Stderr)
+	}
+	if config.Trace != nil {
+		if err := config.Trace.WriteFile(*tracePath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"proj3/concurrent"
+	"strings"
+)
+
+const verifyUsage = "Usage: editor verify [-modes s,ws,mr,pipe] [-threads N] [-out dir] data_dir [effects.txt ...]\n" +
+	"Runs the requests of the effects files (default ../data/effects.txt) under each mode, writing\n" +
+	"to out/<mode> (default ../data/verify), and checks every mode wrote byte-identical files to the\n" +
+	"first. Failed requests and missing, extra and differing files are listed.\n"
+
+// runVerify implements "editor verify" and returns the exit status: 0 when
+// all modes agree, 1 when they don't or a request failed and 2 on errors.
+func runVerify(args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, verifyUsage) }
+	modes := flags.String("modes", "s,ws,mr,pipe", "")
+	threads := flags.Int("threads", 4, "")
+	outRoot := flags.String("out", "../data/verify", "")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	config := concurrent.Config{
+		DataDirs:     flags.Arg(0),
+		ThreadCount:  *threads,
+		EffectsFiles: flags.Args()[1:],
+	}
+	report, err := concurrent.Verify(config, strings.Split(*modes, ","), *outRoot)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	for _, mode := range report.Modes {
+		for _, failure := range report.Failed[mode] {
+			fmt.Printf("%s: failed %s\n", mode, failure)
+		}
+	}
+	for _, mode := range report.Modes[1:] {
+		for _, f := range report.Missing[mode] {
+			fmt.Printf("%s: missing %s\n", mode, f)
+		}
+		for _, f := range report.Extra[mode] {
+			fmt.Printf("%s: extra %s\n", mode, f)
+		}
+		for _, f := range report.Differing[mode] {
+			fmt.Printf("%s: differs %s\n", mode, f)
+		}
+	}
+	if !report.OK() {
+		return 1
+	}
+	fmt.Printf("%d outputs identical across %s\n", len(report.Reference), strings.Join(report.Modes, ", "))
+	return 0
+}
@@ -1,3 +1,5 @@
 module proj3
 
 go 1.19
+
+require golang.org/x/image v0.24.0
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"image"
+	"image/color"
+)
+
+// Heatmap returns an image of where a and b differ. Each pixel shows the
+// largest channel difference there on a black, red, yellow, white ramp,
+// with white at scale (in 16-bit units) or above. A scale of 0 uses the
+// largest difference in the images, so that any difference is visible.
+func Heatmap(a, b image.Image, scale uint16) (*image.RGBA, error) {
+	pa, pb, err := planesOf(a, b)
+	if err != nil {
+		return nil, err
+	}
+	if scale == 0 {
+		scale = pa.maxAbsDiff(pb)
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, pa.w, pa.h))
+	for i := range pa.c[0] {
+		var d uint16
+		for k := range pa.c {
+			if v := absDiff(pa.c[k][i], pb.c[k][i]); v > d {
+				d = v
+			}
+		}
+		t := 0.0
+		if d > 0 {
+			t = float64(d) / float64(scale)
+		}
+		out.SetRGBA(i%pa.w, i/pa.w, ramp(t))
+	}
+	return out, nil
+}
+
+// ramp maps t in [0, 1] to black, red, yellow and white in equal steps.
+func ramp(t float64) color.RGBA {
+	if t > 1 {
+		t = 1
+	}
+	step := func(lo float64) uint8 {
+		v := (t - lo) * 3
+		if v <= 0 {
+			return 0
+		}
+		if v >= 1 {
+			return 255
+		}
+		return uint8(v*255 + 0.5)
+	}
+	return color.RGBA{step(0), step(1.0 / 3), step(2.0 / 3), 255}
+}
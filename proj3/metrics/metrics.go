@@ -0,0 +1,172 @@
+// Package metrics compares two images, to check whether a change to an
+// effect changed its output and by how much.
+//
+// Colour components are compared alpha-premultiplied, as the png package
+// stores them, and scaled to [0, 1]. MSE and PSNR cover all four channels;
+// SSIM and MS-SSIM are computed on the Rec. 709 luma, as is usual.
+package metrics
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Report holds every metric for a pair of images.
+type Report struct {
+	MSE        float64 `json:"mse"`
+	PSNR       float64 `json:"psnr"`
+	SSIM       float64 `json:"ssim"`
+	MSSSIM     float64 `json:"msssim"`
+	MaxAbsDiff uint16  `json:"maxAbsDiff"`
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("MSE %.3g, PSNR %.2f dB, SSIM %.5f, MS-SSIM %.5f, max abs diff %d",
+		r.MSE, r.PSNR, r.SSIM, r.MSSSIM, r.MaxAbsDiff)
+}
+
+// Compare computes all metrics for a and b, which must be the same size.
+func Compare(a, b image.Image) (Report, error) {
+	pa, pb, err := planesOf(a, b)
+	if err != nil {
+		return Report{}, err
+	}
+	var r Report
+	r.MSE = pa.mse(pb)
+	r.PSNR = psnr(r.MSE)
+	r.MaxAbsDiff = pa.maxAbsDiff(pb)
+	la, lb := pa.luma(), pb.luma()
+	r.SSIM = ssim(la, lb)
+	r.MSSSIM = msssim(la, lb)
+	return r, nil
+}
+
+// MSE returns the mean squared difference of the channels of a and b.
+func MSE(a, b image.Image) (float64, error) {
+	pa, pb, err := planesOf(a, b)
+	if err != nil {
+		return 0, err
+	}
+	return pa.mse(pb), nil
+}
+
+// PSNR returns the peak signal to noise ratio of b against a in decibels;
+// identical images give +Inf.
+func PSNR(a, b image.Image) (float64, error) {
+	mse, err := MSE(a, b)
+	if err != nil {
+		return 0, err
+	}
+	return psnr(mse), nil
+}
+
+// MaxAbsDiff returns the largest difference of any channel of any pixel, in
+// 16-bit units.
+func MaxAbsDiff(a, b image.Image) (uint16, error) {
+	pa, pb, err := planesOf(a, b)
+	if err != nil {
+		return 0, err
+	}
+	return pa.maxAbsDiff(pb), nil
+}
+
+// SSIM returns the mean structural similarity of a and b, 1 for identical
+// images.
+func SSIM(a, b image.Image) (float64, error) {
+	pa, pb, err := planesOf(a, b)
+	if err != nil {
+		return 0, err
+	}
+	return ssim(pa.luma(), pb.luma()), nil
+}
+
+// MSSSIM returns the multi-scale structural similarity of a and b, which
+// also weighs differences that only show at coarser scales.
+func MSSSIM(a, b image.Image) (float64, error) {
+	pa, pb, err := planesOf(a, b)
+	if err != nil {
+		return 0, err
+	}
+	return msssim(pa.luma(), pb.luma()), nil
+}
+
+func psnr(mse float64) float64 {
+	if mse == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(1/mse)
+}
+
+// planes holds the premultiplied channels of an image, each row-major.
+type planes struct {
+	w, h int
+	c    [4][]uint16
+}
+
+func planesOf(a, b image.Image) (planes, planes, error) {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return planes{}, planes{}, fmt.Errorf("images are %v and %v, not the same size",
+			a.Bounds().Size(), b.Bounds().Size())
+	}
+	return toPlanes(a), toPlanes(b), nil
+}
+
+func toPlanes(m image.Image) planes {
+	bounds := m.Bounds()
+	p := planes{w: bounds.Dx(), h: bounds.Dy()}
+	for k := range p.c {
+		p.c[k] = make([]uint16, p.w*p.h)
+	}
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x, i = x+1, i+1 {
+			r, g, b, a := m.At(x, y).RGBA()
+			p.c[0][i], p.c[1][i], p.c[2][i], p.c[3][i] = uint16(r), uint16(g), uint16(b), uint16(a)
+		}
+	}
+	return p
+}
+
+func (p planes) mse(q planes) float64 {
+	n := len(p.c[0]) * 4
+	if n == 0 {
+		return 0
+	}
+	sum := 0.0
+	for k := range p.c {
+		for i, v := range p.c[k] {
+			d := (float64(v) - float64(q.c[k][i])) / 65535
+			sum += d * d
+		}
+	}
+	return sum / float64(n)
+}
+
+func (p planes) maxAbsDiff(q planes) uint16 {
+	var max uint16
+	for k := range p.c {
+		for i, v := range p.c[k] {
+			if d := absDiff(v, q.c[k][i]); d > max {
+				max = d
+			}
+		}
+	}
+	return max
+}
+
+func absDiff(a, b uint16) uint16 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// luma returns the Rec. 709 luma of every pixel in [0, 1].
+func (p planes) luma() plane {
+	l := plane{w: p.w, h: p.h, v: make([]float64, p.w*p.h)}
+	for i := range l.v {
+		l.v[i] = (0.2126*float64(p.c[0][i]) + 0.7152*float64(p.c[1][i]) + 0.0722*float64(p.c[2][i])) / 65535
+	}
+	return l
+}
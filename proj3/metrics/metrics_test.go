@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// textured returns a w x h gray image with structure at several scales.
+func textured(w, h int) *image.Gray {
+	m := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := 128 + 60*math.Sin(float64(x)/3) + 40*math.Cos(float64(y)/11) + float64((x*y)%17)
+			m.SetGray(x, y, color.Gray{uint8(v)})
+		}
+	}
+	return m
+}
+
+// noisy returns m with uniform noise of up to amount levels added.
+func noisy(m *image.Gray, amount int, seed int64) *image.Gray {
+	rng := rand.New(rand.NewSource(seed))
+	out := image.NewGray(m.Rect)
+	for i, v := range m.Pix {
+		n := int(v) + rng.Intn(2*amount+1) - amount
+		if n < 0 {
+			n = 0
+		} else if n > 255 {
+			n = 255
+		}
+		out.Pix[i] = uint8(n)
+	}
+	return out
+}
+
+func TestIdentical(t *testing.T) {
+	m := textured(64, 48)
+	r, err := Compare(m, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.MSE != 0 || !math.IsInf(r.PSNR, 1) || r.MaxAbsDiff != 0 {
+		t.Errorf("got %v for identical images", r)
+	}
+	if math.Abs(r.SSIM-1) > 1e-12 || math.Abs(r.MSSSIM-1) > 1e-12 {
+		t.Errorf("SSIM %v and MS-SSIM %v for identical images, want 1", r.SSIM, r.MSSSIM)
+	}
+}
+
+func TestKnownDifference(t *testing.T) {
+	a := image.NewGray(image.Rect(0, 0, 10, 10))
+	b := image.NewGray(image.Rect(0, 0, 10, 10))
+	for i := range b.Pix {
+		a.Pix[i] = 100
+		b.Pix[i] = 110
+	}
+	r, err := Compare(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three of the four channels differ by 10/255.
+	wantMSE := 3 * (10.0 / 255) * (10.0 / 255) / 4
+	if math.Abs(r.MSE-wantMSE) > 1e-12 {
+		t.Errorf("MSE %v, want %v", r.MSE, wantMSE)
+	}
+	if want := 10 * math.Log10(1/wantMSE); math.Abs(r.PSNR-want) > 1e-9 {
+		t.Errorf("PSNR %v, want %v", r.PSNR, want)
+	}
+	if r.MaxAbsDiff != 10*0x101 {
+		t.Errorf("max abs diff %d, want %d", r.MaxAbsDiff, 10*0x101)
+	}
+	// A flat image shifted in brightness keeps its structure.
+	if r.SSIM >= 1 || r.SSIM < 0.99 {
+		t.Errorf("SSIM %v for a small brightness shift", r.SSIM)
+	}
+}
+
+func TestSSIMFallsWithNoise(t *testing.T) {
+	m := textured(128, 128)
+	prevSSIM, prevMS := 1.0, 1.0
+	for _, amount := range []int{2, 8, 32, 96} {
+		n := noisy(m, amount, 1)
+		ssim, err := SSIM(m, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ms, err := MSSSIM(m, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ssim >= prevSSIM || ms >= prevMS {
+			t.Errorf("noise %d: SSIM %v, MS-SSIM %v did not fall from %v, %v", amount, ssim, ms, prevSSIM, prevMS)
+		}
+		prevSSIM, prevMS = ssim, ms
+	}
+}
+
+func TestSizeMismatch(t *testing.T) {
+	if _, err := Compare(textured(10, 10), textured(10, 11)); err == nil {
+		t.Error("no error comparing images of different sizes")
+	}
+}
+
+func TestSmallImages(t *testing.T) {
+	// Smaller than the SSIM window in one dimension.
+	a, b := textured(30, 4), noisy(textured(30, 4), 20, 2)
+	r, err := Compare(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.IsNaN(r.SSIM) || math.IsNaN(r.MSSSIM) || r.SSIM >= 1 {
+		t.Errorf("got %v", r)
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	a := image.NewGray(image.Rect(0, 0, 3, 1))
+	b := image.NewGray(image.Rect(0, 0, 3, 1))
+	b.Pix[1], b.Pix[2] = 100, 200
+	heatmap, err := Heatmap(a, b, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := heatmap.RGBAAt(0, 0); c != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("equal pixel is %v, want black", c)
+	}
+	if c := heatmap.RGBAAt(2, 0); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("largest difference is %v, want white", c)
+	}
+	if c := heatmap.RGBAAt(1, 0); c.R != 255 || c.G == 0 || c.G == 255 || c.B != 0 {
+		t.Errorf("half difference is %v, want between red and yellow", c)
+	}
+}
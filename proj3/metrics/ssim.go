@@ -0,0 +1,168 @@
+package metrics
+
+import "math"
+
+// SSIM follows Wang et al., "Image quality assessment: from error visibility
+// to structural similarity" (2004): local statistics under an 11x11
+// Gaussian window with sigma 1.5, K1 = 0.01 and K2 = 0.03. MS-SSIM follows
+// Wang, Simoncelli and Bovik, "Multi-scale structural similarity for image
+// quality assessment" (2003) with its five scale weights. Only windows that
+// fit entirely inside the image are used; images smaller than the window
+// use a window as large as they are, and MS-SSIM uses as many scales as the
+// image allows.
+
+const (
+	ssimWindow = 11
+	ssimSigma  = 1.5
+	ssimC1     = 0.01 * 0.01
+	ssimC2     = 0.03 * 0.03
+)
+
+var msssimWeights = []float64{0.0448, 0.2856, 0.3001, 0.2363, 0.1333}
+
+// plane is a single channel image.
+type plane struct {
+	w, h int
+	v    []float64
+}
+
+// ssim returns the mean SSIM of a and b.
+func ssim(a, b plane) float64 {
+	l, cs := ssimTerms(a, b)
+	return mean(product(l, cs))
+}
+
+// msssim returns the MS-SSIM of a and b: the contrast-structure terms of
+// every scale and the luminance term of the coarsest, weighted.
+func msssim(a, b plane) float64 {
+	scales := 1
+	for scales < len(msssimWeights) && a.w>>scales >= ssimWindow && a.h>>scales >= ssimWindow {
+		scales++
+	}
+	weights := msssimWeights[:scales]
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+
+	result := 1.0
+	for s, w := range weights {
+		l, cs := ssimTerms(a, b)
+		v := mean(cs)
+		if s == scales-1 {
+			v = mean(product(l, cs))
+		}
+		// Negative values only arise for anti-correlated images; treat them
+		// as no similarity at all.
+		result *= math.Pow(math.Max(v, 0), w/total)
+		a, b = a.half(), b.half()
+	}
+	return result
+}
+
+// ssimTerms returns the luminance and contrast-structure terms of SSIM for
+// every window position.
+func ssimTerms(a, b plane) (l, cs []float64) {
+	kernel := gaussian(a.w, a.h)
+	muA, muB := a.filter(kernel), b.filter(kernel)
+	aa, bb, ab := a.mul(a).filter(kernel), b.mul(b).filter(kernel), a.mul(b).filter(kernel)
+
+	l = make([]float64, len(muA.v))
+	cs = make([]float64, len(muA.v))
+	for i := range l {
+		ma, mb := muA.v[i], muB.v[i]
+		varA, varB, cov := aa.v[i]-ma*ma, bb.v[i]-mb*mb, ab.v[i]-ma*mb
+		l[i] = (2*ma*mb + ssimC1) / (ma*ma + mb*mb + ssimC1)
+		cs[i] = (2*cov + ssimC2) / (varA + varB + ssimC2)
+	}
+	return l, cs
+}
+
+// gaussian returns the normalised 1D window for a w x h image.
+func gaussian(w, h int) []float64 {
+	size := ssimWindow
+	if w < size {
+		size = w
+	}
+	if h < size {
+		size = h
+	}
+	kernel := make([]float64, size)
+	sum := 0.0
+	for i := range kernel {
+		d := float64(i) - float64(size-1)/2
+		kernel[i] = math.Exp(-d * d / (2 * ssimSigma * ssimSigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+	return kernel
+}
+
+// filter convolves p with the separable kernel, keeping only positions
+// where the window fits.
+func (p plane) filter(kernel []float64) plane {
+	n := len(kernel)
+	rows := plane{w: p.w - n + 1, h: p.h, v: make([]float64, (p.w-n+1)*p.h)}
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < rows.w; x++ {
+			sum := 0.0
+			for k, c := range kernel {
+				sum += c * p.v[y*p.w+x+k]
+			}
+			rows.v[y*rows.w+x] = sum
+		}
+	}
+	out := plane{w: rows.w, h: p.h - n + 1, v: make([]float64, rows.w*(p.h-n+1))}
+	for y := 0; y < out.h; y++ {
+		for x := 0; x < out.w; x++ {
+			sum := 0.0
+			for k, c := range kernel {
+				sum += c * rows.v[(y+k)*rows.w+x]
+			}
+			out.v[y*out.w+x] = sum
+		}
+	}
+	return out
+}
+
+func (p plane) mul(q plane) plane {
+	out := plane{w: p.w, h: p.h, v: make([]float64, len(p.v))}
+	for i := range p.v {
+		out.v[i] = p.v[i] * q.v[i]
+	}
+	return out
+}
+
+// half downsamples p by averaging 2x2 blocks.
+func (p plane) half() plane {
+	out := plane{w: p.w / 2, h: p.h / 2}
+	out.v = make([]float64, out.w*out.h)
+	for y := 0; y < out.h; y++ {
+		for x := 0; x < out.w; x++ {
+			i := 2*y*p.w + 2*x
+			out.v[y*out.w+x] = (p.v[i] + p.v[i+1] + p.v[i+p.w] + p.v[i+p.w+1]) / 4
+		}
+	}
+	return out
+}
+
+func product(a, b []float64) []float64 {
+	out := make([]float64, len(a))
+	for i := range a {
+		out[i] = a[i] * b[i]
+	}
+	return out
+}
+
+func mean(v []float64) float64 {
+	if len(v) == 0 {
+		return 1
+	}
+	sum := 0.0
+	for _, x := range v {
+		sum += x
+	}
+	return sum / float64(len(v))
+}
@@ -0,0 +1,236 @@
+package png
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// LUT maps every 16-bit channel value to a new one.
+type LUT []uint16
+
+// NewLUT builds a LUT by evaluating f on each level scaled to [0, 1]; the
+// result is clamped back into [0, 1].
+func NewLUT(f func(v float64) float64) LUT {
+	lut := make(LUT, 1<<16)
+	for i := range lut {
+		lut[i] = clamp(math.Round(f(float64(i)/65535) * 65535))
+	}
+	return lut
+}
+
+// ApplyLUT maps the red, green and blue channels of every pixel through lut.
+// The mapping is applied to straight (not premultiplied) colour so partly
+// transparent pixels are adjusted like opaque ones; alpha is unchanged.
+func (img *Image) ApplyLUT(lut LUT) {
+	bounds := img.out.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			img.out.SetRGBA64(x, y, lut.lookup(img.in.RGBA64At(x, y)))
+		}
+	}
+}
+
+// lookup maps the straight colour of the premultiplied pixel c through lut.
+func (lut LUT) lookup(c color.RGBA64) color.RGBA64 {
+	switch c.A {
+	case 0:
+	case 0xffff:
+		c.R, c.G, c.B = lut[c.R], lut[c.G], lut[c.B]
+	default:
+		u, a := unpremultiplied(c), uint32(c.A)
+		c.R = uint16(uint32(lut[u.R]) * a / 0xffff)
+		c.G = uint16(uint32(lut[u.G]) * a / 0xffff)
+		c.B = uint16(uint32(lut[u.B]) * a / 0xffff)
+	}
+	return c
+}
+
+// BrightnessContrast shifts brightness and scales contrast around mid grey.
+// Both amounts are in [-1, 1] where 0 leaves the image unchanged; contrast 1
+// becomes a hard threshold and -1 flat grey.
+func (img *Image) BrightnessContrast(brightness, contrast float64) {
+	// Map contrast onto the slope of the line through mid grey, from 0
+	// (flat) through 1 (unchanged) to vertical.
+	slope := math.Tan((math.Max(-1, math.Min(1, contrast)) + 1) * math.Pi / 4)
+	img.ApplyLUT(NewLUT(func(v float64) float64 {
+		return (v-0.5)*slope + 0.5 + brightness
+	}))
+}
+
+// Gamma applies a power curve v^(1/gamma), so values above 1 brighten the
+// midtones and values below 1 darken them.
+func (img *Image) Gamma(gamma float64) {
+	img.ApplyLUT(NewLUT(func(v float64) float64 {
+		return math.Pow(v, 1/gamma)
+	}))
+}
+
+// Levels remaps the input range [inBlack, inWhite] onto [outBlack, outWhite]
+// with a midtone gamma applied in between, like the levels dialog of an image
+// editor. All points are in [0, 1].
+func (img *Image) Levels(inBlack, inWhite, midtone, outBlack, outWhite float64) {
+	img.ApplyLUT(NewLUT(func(v float64) float64 {
+		v = math.Max(0, math.Min(1, (v-inBlack)/(inWhite-inBlack)))
+		return outBlack + math.Pow(v, 1/midtone)*(outWhite-outBlack)
+	}))
+}
+
+// Curves maps tones through a smooth curve passing through the control
+// points, each an (input, output) pair in [0, 1]. The curve is a monotone
+// cubic spline, so it never overshoots between points; inputs outside the
+// first and last points are held at their outputs.
+func (img *Image) Curves(points []Point) error {
+	curve, err := newToneCurve(points)
+	if err != nil {
+		return err
+	}
+	img.ApplyLUT(NewLUT(curve.at))
+	return nil
+}
+
+// toneCurve is a monotone cubic Hermite spline (Fritsch-Carlson).
+type toneCurve struct {
+	xs, ys, slopes []float64
+}
+
+func newToneCurve(points []Point) (*toneCurve, error) {
+	if len(points) < 2 {
+		return nil, fmt.Errorf("curves: need at least 2 control points, got %d", len(points))
+	}
+	sorted := append([]Point(nil), points...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].X < sorted[j].X })
+
+	n := len(sorted)
+	curve := &toneCurve{xs: make([]float64, n), ys: make([]float64, n), slopes: make([]float64, n)}
+	for i, p := range sorted {
+		if i > 0 && p.X == sorted[i-1].X {
+			return nil, fmt.Errorf("curves: two control points at input %v", p.X)
+		}
+		curve.xs[i], curve.ys[i] = p.X, p.Y
+	}
+
+	secants := make([]float64, n-1)
+	for i := range secants {
+		secants[i] = (curve.ys[i+1] - curve.ys[i]) / (curve.xs[i+1] - curve.xs[i])
+	}
+	curve.slopes[0], curve.slopes[n-1] = secants[0], secants[n-2]
+	for i := 1; i < n-1; i++ {
+		if secants[i-1]*secants[i] <= 0 {
+			curve.slopes[i] = 0
+		} else {
+			curve.slopes[i] = (secants[i-1] + secants[i]) / 2
+		}
+	}
+	// Limit the slopes so each segment stays monotone.
+	for i, s := range secants {
+		if s == 0 {
+			curve.slopes[i], curve.slopes[i+1] = 0, 0
+			continue
+		}
+		a, b := curve.slopes[i]/s, curve.slopes[i+1]/s
+		if h := a*a + b*b; h > 9 {
+			t := 3 / math.Sqrt(h)
+			curve.slopes[i] = t * a * s
+			curve.slopes[i+1] = t * b * s
+		}
+	}
+	return curve, nil
+}
+
+// at evaluates the curve at v.
+func (curve *toneCurve) at(v float64) float64 {
+	n := len(curve.xs)
+	if v <= curve.xs[0] {
+		return curve.ys[0]
+	}
+	if v >= curve.xs[n-1] {
+		return curve.ys[n-1]
+	}
+	i := sort.SearchFloat64s(curve.xs, v) - 1
+	h := curve.xs[i+1] - curve.xs[i]
+	t := (v - curve.xs[i]) / h
+	t2, t3 := t*t, t*t*t
+	return (2*t3-3*t2+1)*curve.ys[i] +
+		(t3-2*t2+t)*h*curve.slopes[i] +
+		(-2*t3+3*t2)*curve.ys[i+1] +
+		(t3-t2)*h*curve.slopes[i+1]
+}
+
+// runAdjust applies the tonal adjustment entries from effects.txt:
+//
+//	brightness:v=0.1            shift in [-1, 1]
+//	contrast:v=0.2              amount in [-1, 1]
+//	gamma:v=2.2                 power curve exponent 1/v
+//	levels:black=,white=,mid=,outblack=,outwhite=
+//	                            points in [0, 1], mid is a gamma (default 1)
+//	curves:points=0 0 0.5 0.6 1 1
+//	                            (input, output) control points in [0, 1]
+func (img *Image) runAdjust(spec effectSpec) error {
+	switch spec.name {
+	case "brightness", "contrast", "gamma":
+		def := 0.0
+		if spec.name == "gamma" {
+			def = 1
+		}
+		v, err := spec.float("v", def)
+		if err != nil {
+			return err
+		}
+		switch spec.name {
+		case "brightness":
+			img.BrightnessContrast(v, 0)
+		case "contrast":
+			img.BrightnessContrast(0, v)
+		default:
+			if v <= 0 {
+				return fmt.Errorf("gamma: v must be positive")
+			}
+			img.Gamma(v)
+		}
+	case "levels":
+		var args [5]float64
+		for i, key := range []string{"black", "white", "mid", "outblack", "outwhite"} {
+			def := []float64{0, 1, 1, 0, 1}[i]
+			v, err := spec.float(key, def)
+			if err != nil {
+				return err
+			}
+			args[i] = v
+		}
+		if args[1] <= args[0] || args[2] <= 0 {
+			return fmt.Errorf("levels: need black < white and a positive mid")
+		}
+		img.Levels(args[0], args[1], args[2], args[3], args[4])
+	case "curves":
+		coords, err := spec.floats("points")
+		if err != nil {
+			return err
+		}
+		if len(coords)%2 != 0 {
+			return fmt.Errorf("curves: points needs (input, output) pairs, got %d numbers", len(coords))
+		}
+		points, err := spec.points("points", len(coords)/2)
+		if err != nil {
+			return err
+		}
+		return img.Curves(points)
+	}
+	return nil
+}
+
+// unpremultiplied returns c with its colour divided by alpha.
+func unpremultiplied(c color.RGBA64) color.RGBA64 {
+	if c.A == 0 || c.A == 0xffff {
+		return c
+	}
+	a := uint32(c.A)
+	unmul := func(v uint16) uint16 {
+		if v >= c.A {
+			return 0xffff
+		}
+		return uint16(uint32(v) * 0xffff / a)
+	}
+	return color.RGBA64{unmul(c.R), unmul(c.G), unmul(c.B), c.A}
+}
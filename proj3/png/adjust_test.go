@@ -0,0 +1,106 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// ramp returns a 1024x1 grey ramp from black to white.
+func ramp() image.Image {
+	img := image.NewGray16(image.Rect(0, 0, 1024, 1))
+	for x := 0; x < 1024; x++ {
+		img.SetGray16(x, 0, color.Gray16{uint16(x * 65535 / 1023)})
+	}
+	return img
+}
+
+func TestToneAdjustmentsMonotonic(t *testing.T) {
+	for _, effect := range []string{
+		"levels:black=0.1,white=0.8",
+		"levels:black=0.2,white=0.7,mid=0.4,outblack=0.1,outwhite=0.9",
+		"levels:mid=3",
+		"curves:points=0 0 0.5 0.6 1 1",
+		"curves:points=0 0.1 0.3 0.15 0.35 0.8 0.9 0.85 1 1",
+		"curves:points=0.2 0 0.25 1",
+		"gamma:v=2.2",
+		"contrast:v=0.7",
+	} {
+		out := runOn(ramp(), false, effect)
+		prev := uint16(0)
+		for x := 0; x < 1024; x++ {
+			c := out.RGBA64At(x, 0)
+			if c.R != c.G || c.G != c.B {
+				t.Fatalf("%s: grey input became %v", effect, c)
+			}
+			if c.R < prev {
+				t.Errorf("%s: output falls from %d to %d at %d", effect, prev, c.R, x)
+				break
+			}
+			prev = c.R
+		}
+	}
+}
+
+func TestLevelsEndpoints(t *testing.T) {
+	out := runOn(ramp(), false, "levels:black=0.25,white=0.75,outblack=0.1,outwhite=0.9")
+	for _, test := range []struct {
+		x    int
+		want uint16
+	}{
+		{0, 6554}, {255, 6554}, {512, 32768}, {768, 58982}, {1023, 58982},
+	} {
+		if got := out.RGBA64At(test.x, 0).R; absDiff(uint32(got), uint32(test.want)) > 80 {
+			t.Errorf("x=%d: %d, want %d", test.x, got, test.want)
+		}
+	}
+}
+
+func TestToneCurveNoOvershoot(t *testing.T) {
+	points := []Point{{0, 0}, {0.3, 0.1}, {0.35, 0.9}, {1, 1}}
+	curve, err := newToneCurve(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range points {
+		if got := curve.at(p.X); got != p.Y {
+			t.Errorf("curve at %v = %v, want %v", p.X, got, p.Y)
+		}
+	}
+	prev := curve.at(0)
+	for i := 1; i <= 1000; i++ {
+		v := curve.at(float64(i) / 1000)
+		if v < prev-1e-12 {
+			t.Fatalf("curve falls at %v", float64(i)/1000)
+		}
+		prev = v
+	}
+	// Flat between equal outputs, with no bump between the points.
+	flat, _ := newToneCurve([]Point{{0, 0}, {0.4, 0.5}, {0.6, 0.5}, {1, 1}})
+	for i := 0; i <= 20; i++ {
+		if v := flat.at(0.4 + 0.01*float64(i)); v != 0.5 {
+			t.Fatalf("flat segment reaches %v", v)
+		}
+	}
+
+	if _, err := newToneCurve([]Point{{0.5, 0}, {0.5, 1}}); err == nil {
+		t.Errorf("expected an error for two points at the same input")
+	}
+}
+
+func TestNonFiniteArguments(t *testing.T) {
+	for _, effect := range []string{
+		"brightness:v=NaN",
+		"contrast:v=inf",
+		"gamma:v=-Inf",
+		"levels:mid=+inf",
+		"curves:points=0 0 nan 0.5 1 1",
+		"resize:scale=inf",
+		"affine:m=1 0 0 0 1 NaN",
+	} {
+		img := newImage(ramp())
+		if err := img.applyEffect(effect); err == nil {
+			t.Errorf("%q: no error", effect)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// haloImage returns an opaque red square in the middle of a fully
+// transparent image whose hidden colour is bright green, the classic source
+// of fringing when alpha is handled as straight colour.
+func haloImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 12, 12))
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 12; x++ {
+			if x >= 4 && x < 8 && y >= 4 && y < 8 {
+				img.Set(x, y, color.NRGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.NRGBA{0, 255, 0, 0})
+			}
+		}
+	}
+	return img
+}
+
+// loadThroughFile saves src as a PNG and loads it back with Load.
+func loadThroughFile(t *testing.T, src image.Image) *Image {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "in.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, src); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	img, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestConvolutionNoFringing(t *testing.T) {
+	for _, effect := range []string{"B", "S", "resize:w=7,filter=lanczos", "rotate:deg=30,filter=bicubic"} {
+		img := loadThroughFile(t, haloImage())
+		img.RunEffects([]string{effect})
+		b := img.out.Bounds()
+		sawPartial := false
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := img.out.RGBA64At(x, y)
+				if c.G != 0 || c.B != 0 {
+					t.Fatalf("%s: pixel (%d, %d) = %v picked up the hidden colour", effect, x, y, c)
+				}
+				if c.R > c.A {
+					t.Fatalf("%s: pixel (%d, %d) = %v is not validly premultiplied", effect, x, y, c)
+				}
+				if c.A > 0 && c.A < 0xffff {
+					sawPartial = true
+				}
+			}
+		}
+		if effect == "B" && !sawPartial {
+			t.Errorf("%s: alpha was not blurred", effect)
+		}
+	}
+}
+
+func TestConvolutionAlpha(t *testing.T) {
+	img := loadThroughFile(t, haloImage())
+	img.Blur()
+
+	// Inside the square nothing changes; one pixel outside, a third of the
+	// 3x3 window is covered by the square.
+	if c := img.out.RGBA64At(5, 5); c.A != 0xffff {
+		t.Errorf("centre alpha = %d, want 65535", c.A)
+	}
+	if c := img.out.RGBA64At(3, 5); absDiff(uint32(c.A), 0xffff/3) > 1 {
+		t.Errorf("edge alpha = %d, want %d", c.A, 0xffff/3)
+	}
+	if c := img.out.RGBA64At(0, 0); c.A != 0 {
+		t.Errorf("corner alpha = %d, want 0", c.A)
+	}
+
+	// Edge detection keeps the alpha of each pixel.
+	img = loadThroughFile(t, haloImage())
+	img.EdgeDetection()
+	if c := img.out.RGBA64At(5, 5); c.A != 0xffff {
+		t.Errorf("edge detection: centre alpha = %d, want 65535", c.A)
+	}
+}
+
+func TestConvolutionBorder(t *testing.T) {
+	// Edge pixels are repeated beyond the border, so a uniform opaque
+	// image comes out of blur and sharpen unchanged and edge detection
+	// finds no edges, corners included.
+	want := color.RGBA64Model.Convert(color.NRGBA{90, 160, 30, 255}).(color.RGBA64)
+	for _, effect := range []string{"B", "S", "E"} {
+		out := runOn(solid(5, 4, color.NRGBA{90, 160, 30, 255}), false, effect)
+		if effect == "E" {
+			want = color.RGBA64{0, 0, 0, 0xffff}
+		}
+		for _, p := range []image.Point{{0, 0}, {4, 0}, {2, 0}, {0, 2}, {4, 3}} {
+			if c := out.RGBA64At(p.X, p.Y); c != want {
+				t.Errorf("%s: border pixel %v = %v, want %v", effect, p, c, want)
+			}
+		}
+	}
+}
+
+func TestSaveStraightAlpha(t *testing.T) {
+	img := loadThroughFile(t, haloImage())
+	img.RunEffects([]string{"B"})
+	path := filepath.Join(t.TempDir(), "out.png")
+	if err := img.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	saved, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A half covered pixel must still be pure red once unpremultiplied.
+	c := color.NRGBA64Model.Convert(saved.At(3, 5)).(color.NRGBA64)
+	if c.A == 0 || c.R < 0xff00 || c.G != 0 || c.B != 0 {
+		t.Errorf("saved edge pixel = %v, want opaque-looking red with partial alpha", c)
+	}
+}
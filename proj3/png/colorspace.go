@@ -0,0 +1,313 @@
+package png
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// Colour space conversions. Unless stated otherwise components are in
+// [0, 1]; hues are in degrees [0, 360); Lab uses the D65 white point with L*
+// in [0, 100].
+
+// SRGBToLinear decodes a gamma-encoded sRGB component to linear light.
+func SRGBToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// LinearToSRGB encodes a linear light component with the sRGB transfer curve.
+func LinearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// RGBToHSV converts sRGB to hue, saturation and value.
+func RGBToHSV(r, g, b float64) (h, s, v float64) {
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	v = max
+	if max > 0 {
+		s = (max - min) / max
+	}
+	return hue(r, g, b, max, min), s, v
+}
+
+// HSVToRGB converts hue, saturation and value to sRGB.
+func HSVToRGB(h, s, v float64) (r, g, b float64) {
+	c := v * s
+	return hueToRGB(h, c, v-c)
+}
+
+// RGBToHSL converts sRGB to hue, saturation and lightness.
+func RGBToHSL(r, g, b float64) (h, s, l float64) {
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	l = (max + min) / 2
+	if d := max - min; d > 0 {
+		s = d / (1 - math.Abs(2*l-1))
+	}
+	return hue(r, g, b, max, min), s, l
+}
+
+// HSLToRGB converts hue, saturation and lightness to sRGB.
+func HSLToRGB(h, s, l float64) (r, g, b float64) {
+	c := (1 - math.Abs(2*l-1)) * s
+	return hueToRGB(h, c, l-c/2)
+}
+
+// hue returns the hue shared by HSV and HSL given the largest and smallest
+// components.
+func hue(r, g, b, max, min float64) float64 {
+	d := max - min
+	if d == 0 {
+		return 0
+	}
+	var h float64
+	switch max {
+	case r:
+		h = math.Mod((g-b)/d, 6)
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// hueToRGB builds a colour from its hue, chroma c and the offset m added to
+// every component.
+func hueToRGB(h, c, m float64) (r, g, b float64) {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	hp := h / 60
+	x := c * (1 - math.Abs(math.Mod(hp, 2)-1))
+	switch int(hp) {
+	case 0:
+		r, g, b = c, x, 0
+	case 1:
+		r, g, b = x, c, 0
+	case 2:
+		r, g, b = 0, c, x
+	case 3:
+		r, g, b = 0, x, c
+	case 4:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return r + m, g + m, b + m
+}
+
+// D65 reference white in XYZ.
+const (
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+// RGBToLab converts sRGB to CIELAB.
+func RGBToLab(r, g, b float64) (l, a, bb float64) {
+	r, g, b = SRGBToLinear(r), SRGBToLinear(g), SRGBToLinear(b)
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*b) / whiteX
+	y := (0.2126729*r + 0.7151522*g + 0.0721750*b) / whiteY
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*b) / whiteZ
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// LabToRGB converts CIELAB to sRGB. Colours outside the sRGB gamut are
+// returned unclamped.
+func LabToRGB(l, a, bb float64) (r, g, b float64) {
+	fy := (l + 16) / 116
+	fx := fy + a/500
+	fz := fy - bb/200
+	x, y, z := labFInv(fx)*whiteX, labFInv(fy)*whiteY, labFInv(fz)*whiteZ
+	r = 3.2404542*x - 1.5371385*y - 0.4985314*z
+	g = -0.9692660*x + 1.8760108*y + 0.0415560*z
+	b = 0.0556434*x - 0.2040259*y + 1.0572252*z
+	return LinearToSRGB(math.Max(0, r)), LinearToSRGB(math.Max(0, g)), LinearToSRGB(math.Max(0, b))
+}
+
+const labEpsilon = 216.0 / 24389
+
+func labF(t float64) float64 {
+	if t > labEpsilon {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
+
+func labFInv(t float64) float64 {
+	if t3 := t * t * t; t3 > labEpsilon {
+		return t3
+	}
+	return (116*t - 16) / (24389.0 / 27)
+}
+
+// mapColor replaces the colour of every pixel with f applied to its straight
+// (not premultiplied) sRGB components in [0, 1]. Alpha is unchanged. f may
+// return colours outside the gamut, such as LabToRGB does, so the result is
+// clamped into [0, 1] before premultiplying.
+func (img *Image) mapColor(f func(r, g, b float64) (float64, float64, float64)) {
+	bounds := img.out.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.in.RGBA64At(x, y)
+			if c.A == 0 {
+				img.out.SetRGBA64(x, y, c)
+				continue
+			}
+			u := unpremultiplied(c)
+			r, g, b := f(float64(u.R)/65535, float64(u.G)/65535, float64(u.B)/65535)
+			k := float64(c.A)
+			img.out.SetRGBA64(x, y, color.RGBA64{
+				clampTo(math.Round(r*k), c.A), clampTo(math.Round(g*k), c.A), clampTo(math.Round(b*k), c.A), c.A,
+			})
+		}
+	}
+}
+
+// HueRotate shifts the hue of every pixel by degrees.
+func (img *Image) HueRotate(degrees float64) {
+	img.mapColor(func(r, g, b float64) (float64, float64, float64) {
+		h, s, v := RGBToHSV(r, g, b)
+		return HSVToRGB(h+degrees, s, v)
+	})
+}
+
+// Saturate scales the HSL saturation of every pixel by factor; 0 removes all
+// colour and values above 1 intensify it.
+func (img *Image) Saturate(factor float64) {
+	img.mapColor(func(r, g, b float64) (float64, float64, float64) {
+		h, s, l := RGBToHSL(r, g, b)
+		return HSLToRGB(h, math.Min(1, s*factor), l)
+	})
+}
+
+// Vibrance raises (amount > 0) or lowers (amount < 0) saturation, weighted
+// towards muted colours so already saturated ones and skin tones are mostly
+// left alone. amount is in [-1, 1].
+func (img *Image) Vibrance(amount float64) {
+	img.mapColor(func(r, g, b float64) (float64, float64, float64) {
+		h, s, l := RGBToHSL(r, g, b)
+		return HSLToRGB(h, math.Max(0, math.Min(1, s*(1+amount*(1-s)))), l)
+	})
+}
+
+// Lightness adds delta to the CIELAB L* of every pixel, brightening or
+// darkening it perceptually evenly without changing its chroma.
+func (img *Image) Lightness(delta float64) {
+	img.mapColor(func(r, g, b float64) (float64, float64, float64) {
+		l, a, bb := RGBToLab(r, g, b)
+		return LabToRGB(math.Max(0, math.Min(100, l+delta)), a, bb)
+	})
+}
+
+// GrayscaleMethod selects how Grayscale weighs the colour channels.
+type GrayscaleMethod int
+
+const (
+	// Average is the plain mean of r, g and b used by the "G" effect.
+	Average GrayscaleMethod = iota
+	// Rec709 is HDTV luma (0.2126 R + 0.7152 G + 0.0722 B).
+	Rec709
+	// Rec601 is SDTV luma (0.299 R + 0.587 G + 0.114 B).
+	Rec601
+	// LabL is the CIELAB lightness L*, the perceptually uniform choice.
+	LabL
+)
+
+// ParseGrayscaleMethod returns the GrayscaleMethod named by name ("average",
+// "rec709", "rec601" or "lab").
+func ParseGrayscaleMethod(name string) (GrayscaleMethod, error) {
+	switch name {
+	case "average":
+		return Average, nil
+	case "rec709":
+		return Rec709, nil
+	case "rec601":
+		return Rec601, nil
+	case "lab":
+		return LabL, nil
+	}
+	return 0, fmt.Errorf("unknown grayscale method %q", name)
+}
+
+// GrayscaleWith converts the image to grayscale using method.
+func (img *Image) GrayscaleWith(method GrayscaleMethod) {
+	switch method {
+	case Rec709, Rec601:
+		wr, wg, wb := lumaR, lumaG, lumaB
+		if method == Rec601 {
+			wr, wg, wb = 0.299, 0.587, 0.114
+		}
+		img.mapColor(func(r, g, b float64) (float64, float64, float64) {
+			y := wr*r + wg*g + wb*b
+			return y, y, y
+		})
+	case LabL:
+		img.mapColor(func(r, g, b float64) (float64, float64, float64) {
+			l, _, _ := RGBToLab(r, g, b)
+			// A neutral grey with the same L* has equal components.
+			y := LinearToSRGB(labFInv((l + 16) / 116))
+			return y, y, y
+		})
+	default:
+		img.Grayscale()
+	}
+}
+
+// runColor applies the colour entries from effects.txt:
+//
+//	hue:deg=30             rotate hue
+//	saturation:v=1.2       scale saturation
+//	vibrance:v=0.3         saturation boost weighted to muted colours, [-1, 1]
+//	lightness:v=10         add to Lab L* (0-100)
+//	grayscale:method=lab   average (default), rec709, rec601 or lab
+func (img *Image) runColor(spec effectSpec) error {
+	if spec.name == "grayscale" {
+		method, err := ParseGrayscaleMethod(spec.str("method", "average"))
+		if err != nil {
+			return err
+		}
+		img.GrayscaleWith(method)
+		return nil
+	}
+
+	key, def := "v", 0.0
+	switch spec.name {
+	case "hue":
+		key = "deg"
+	case "saturation":
+		def = 1
+	}
+	v, err := spec.float(key, def)
+	if err != nil {
+		return err
+	}
+	switch spec.name {
+	case "hue":
+		img.HueRotate(v)
+	case "saturation":
+		if v < 0 {
+			return fmt.Errorf("saturation: v must not be negative")
+		}
+		img.Saturate(v)
+	case "vibrance":
+		img.Vibrance(v)
+	case "lightness":
+		img.Lightness(v)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package png
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+// sampleColours are sRGB triples covering greys, primaries and mixes.
+var sampleColours = [][3]float64{
+	{0, 0, 0}, {1, 1, 1}, {0.5, 0.5, 0.5},
+	{1, 0, 0}, {0, 1, 0}, {0, 0, 1},
+	{1, 1, 0}, {0, 1, 1}, {1, 0, 1},
+	{0.2, 0.4, 0.6}, {0.9, 0.3, 0.1}, {0.05, 0.8, 0.45}, {0.7, 0.7, 0.2},
+}
+
+func closeRGB(t *testing.T, what string, in [3]float64, r, g, b float64) {
+	t.Helper()
+	const tolerance = 1e-9
+	if math.Abs(r-in[0]) > tolerance || math.Abs(g-in[1]) > tolerance || math.Abs(b-in[2]) > tolerance {
+		t.Errorf("%s round trip of %v gave (%g, %g, %g)", what, in, r, g, b)
+	}
+}
+
+func TestColourSpaceRoundTrips(t *testing.T) {
+	for _, c := range sampleColours {
+		h, s, v := RGBToHSV(c[0], c[1], c[2])
+		r, g, b := HSVToRGB(h, s, v)
+		closeRGB(t, "HSV", c, r, g, b)
+
+		h, s, l := RGBToHSL(c[0], c[1], c[2])
+		r, g, b = HSLToRGB(h, s, l)
+		closeRGB(t, "HSL", c, r, g, b)
+
+		// The XYZ matrices are given to seven digits, so Lab round trips
+		// are a little less exact.
+		l, a, bb := RGBToLab(c[0], c[1], c[2])
+		r, g, b = LabToRGB(l, a, bb)
+		if math.Abs(r-c[0]) > 1e-5 || math.Abs(g-c[1]) > 1e-5 || math.Abs(b-c[2]) > 1e-5 {
+			t.Errorf("Lab round trip of %v gave (%g, %g, %g)", c, r, g, b)
+		}
+	}
+}
+
+func TestColourSpaceReferenceValues(t *testing.T) {
+	if h, s, v := RGBToHSV(1, 0.5, 0); h != 30 || s != 1 || v != 1 {
+		t.Errorf("HSV of orange = (%g, %g, %g), want (30, 1, 1)", h, s, v)
+	}
+	if h, s, l := RGBToHSL(0, 0, 0.5); h != 240 || s != 1 || l != 0.25 {
+		t.Errorf("HSL of navy = (%g, %g, %g), want (240, 1, 0.25)", h, s, l)
+	}
+	// White is L* 100 and neutral; sRGB red is about (53.24, 80.09, 67.20).
+	if l, a, b := RGBToLab(1, 1, 1); math.Abs(l-100) > 1e-3 || math.Abs(a) > 1e-3 || math.Abs(b) > 1e-3 {
+		t.Errorf("Lab of white = (%g, %g, %g)", l, a, b)
+	}
+	if l, a, b := RGBToLab(1, 0, 0); math.Abs(l-53.24) > 0.01 || math.Abs(a-80.09) > 0.01 || math.Abs(b-67.20) > 0.01 {
+		t.Errorf("Lab of red = (%g, %g, %g)", l, a, b)
+	}
+}
+
+func TestColourEffectsTranslucent(t *testing.T) {
+	for _, src := range []color.NRGBA{{0, 0, 255, 128}, {255, 255, 0, 40}, {250, 20, 200, 200}} {
+		for _, effect := range []string{"lightness:v=60", "lightness:v=-60", "saturation:v=3", "vibrance:v=1", "hue:deg=120", "grayscale:method=lab"} {
+			c := runOn(solid(2, 2, src), false, effect).RGBA64At(0, 0)
+			if c.R > c.A || c.G > c.A || c.B > c.A {
+				t.Errorf("%s on %v: %v is not validly premultiplied", effect, src, c)
+			}
+			if want := uint16(src.A) * 0x101; c.A != want {
+				t.Errorf("%s on %v: alpha changed to %#x", effect, src, c.A)
+			}
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package png
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"sync"
+)
+
+// BlendMode selects how the colours of an overlay mix with the image below.
+type BlendMode int
+
+const (
+	Normal BlendMode = iota
+	Multiply
+	Screen
+	Overlay
+	Darken
+	Lighten
+	Difference
+)
+
+var blendModes = map[string]BlendMode{
+	"normal": Normal, "multiply": Multiply, "screen": Screen, "overlay": Overlay,
+	"darken": Darken, "lighten": Lighten, "difference": Difference,
+}
+
+// ParseBlendMode returns the BlendMode named by name.
+func ParseBlendMode(name string) (BlendMode, error) {
+	if mode, ok := blendModes[name]; ok {
+		return mode, nil
+	}
+	return 0, fmt.Errorf("unknown blend mode %q", name)
+}
+
+// blend mixes a straight backdrop colour cb with a straight source colour cs.
+func (mode BlendMode) blend(cb, cs float64) float64 {
+	switch mode {
+	case Multiply:
+		return cb * cs
+	case Screen:
+		return cb + cs - cb*cs
+	case Overlay:
+		if cb <= 0.5 {
+			return cs * 2 * cb
+		}
+		d := 2*cb - 1
+		return cs + d - cs*d
+	case Darken:
+		return math.Min(cb, cs)
+	case Lighten:
+		return math.Max(cb, cs)
+	case Difference:
+		return math.Abs(cb - cs)
+	}
+	return cs
+}
+
+// Operator is a Porter-Duff compositing operator.
+type Operator int
+
+const (
+	Over Operator = iota
+	Atop
+	In
+	Out
+	Xor
+	DestOver
+)
+
+var operators = map[string]Operator{
+	"over": Over, "atop": Atop, "in": In, "out": Out, "xor": Xor, "dest-over": DestOver,
+}
+
+// ParseOperator returns the Operator named by name.
+func ParseOperator(name string) (Operator, error) {
+	if op, ok := operators[name]; ok {
+		return op, nil
+	}
+	return 0, fmt.Errorf("unknown compositing operator %q", name)
+}
+
+// fractions returns the Porter-Duff fractions of source and backdrop kept by
+// op for source alpha as and backdrop alpha ab.
+func (op Operator) fractions(as, ab float64) (fa, fb float64) {
+	switch op {
+	case Atop:
+		return ab, 1 - as
+	case In:
+		return ab, 0
+	case Out:
+		return 1 - ab, 0
+	case Xor:
+		return 1 - ab, 1 - as
+	case DestOver:
+		return 1 - ab, 1
+	}
+	return 1, 1 - as
+}
+
+// Composite draws top onto the image with its top left corner at at. The
+// overlay's alpha is scaled by opacity, colours are mixed with mode and the
+// result is combined with op, following the W3C compositing model. Outside
+// top the source is transparent, so in and out clear the rest of the image
+// and the other operators leave it as it is.
+func (img *Image) Composite(top *image.RGBA64, at image.Point, opacity float64, mode BlendMode, op Operator) {
+	bounds := img.out.Bounds()
+	// The fraction of the backdrop op keeps under a transparent source,
+	// which is 0 or 1.
+	if _, keep := op.fractions(0, 1); keep > 0 {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				img.out.SetRGBA64(x, y, img.in.RGBA64At(x, y))
+			}
+		}
+	} else {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				img.out.SetRGBA64(x, y, color.RGBA64{})
+			}
+		}
+	}
+
+	offset := at.Sub(top.Bounds().Min)
+	area := top.Bounds().Add(offset).Intersect(bounds)
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			s := top.RGBA64At(x-offset.X, y-offset.Y)
+			b := img.in.RGBA64At(x, y)
+			img.out.SetRGBA64(x, y, compositePixel(b, s, opacity, mode, op))
+		}
+	}
+}
+
+// compositePixel combines the premultiplied source s over the premultiplied
+// backdrop b.
+func compositePixel(b, s color.RGBA64, opacity float64, mode BlendMode, op Operator) color.RGBA64 {
+	ab := float64(b.A) / 65535
+	as := float64(s.A) / 65535 * opacity
+	fa, fb := op.fractions(as, ab)
+	ao := as*fa + ab*fb
+	if ao <= 0 {
+		return color.RGBA64{}
+	}
+
+	mix := func(cb16, cs16 uint16) uint16 {
+		// Straight colours of backdrop and source.
+		var cb, cs float64
+		if b.A > 0 {
+			cb = float64(cb16) / float64(b.A)
+		}
+		if s.A > 0 {
+			cs = float64(cs16) / float64(s.A)
+		}
+		// The source colour as modified by the backdrop it is blended with.
+		blended := (1-ab)*cs + ab*mode.blend(cb, cs)
+		co := as*fa*blended + ab*fb*cb
+		return clamp(math.Round(co * 65535))
+	}
+	a := clamp(math.Round(ao * 65535))
+	return color.RGBA64{
+		min16(mix(b.R, s.R), a), min16(mix(b.G, s.G), a), min16(mix(b.B, s.B), a), a,
+	}
+}
+
+func min16(v, max uint16) uint16 {
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// overlayCache holds overlay images by path so that every request of a run
+// that stamps the same watermark shares one decoded copy. The images are
+// only ever read, so they can be used from many goroutines at once.
+var overlayCache = struct {
+	sync.Mutex
+	entries map[string]*overlayEntry
+}{entries: map[string]*overlayEntry{}}
+
+type overlayEntry struct {
+	once sync.Once
+	img  *image.RGBA64
+	err  error
+}
+
+// LoadOverlay returns the image at filePath, in any format Load accepts,
+// decoding it only the first time it is asked for.
+func LoadOverlay(filePath string) (*image.RGBA64, error) {
+	overlayCache.Lock()
+	entry, ok := overlayCache.entries[filePath]
+	if !ok {
+		entry = &overlayEntry{}
+		overlayCache.entries[filePath] = entry
+	}
+	overlayCache.Unlock()
+
+	entry.once.Do(func() {
+		f, err := os.Open(filePath)
+		if err != nil {
+			entry.err = err
+			return
+		}
+		defer f.Close()
+		src, _, err := image.Decode(f)
+		if err != nil {
+			entry.err = err
+			return
+		}
+		// The overlay is cached for the rest of the run, so it gets a
+		// buffer of its own rather than two from the pool.
+		entry.img = image.NewRGBA64(src.Bounds())
+		copyInto(entry.img, src)
+	})
+	return entry.img, entry.err
+}
+
+// resolve returns path relative to img.Dir, unless it is absolute.
+func (img *Image) resolve(path string) string {
+	return resolvePath(img.Dir, path)
+}
+
+// anchorPoint returns where the top left corner of a size sized overlay goes
+// for the named anchor, with (dx, dy) as the margin from that anchor.
+func anchorPoint(anchor string, bounds image.Rectangle, size image.Point, dx, dy int) (image.Point, error) {
+	left, right := bounds.Min.X+dx, bounds.Max.X-size.X-dx
+	top, bottom := bounds.Min.Y+dy, bounds.Max.Y-size.Y-dy
+	centerX := bounds.Min.X + (bounds.Dx()-size.X)/2 + dx
+	centerY := bounds.Min.Y + (bounds.Dy()-size.Y)/2 + dy
+
+	switch anchor {
+	case "top-left":
+		return image.Pt(left, top), nil
+	case "top":
+		return image.Pt(centerX, top), nil
+	case "top-right":
+		return image.Pt(right, top), nil
+	case "left":
+		return image.Pt(left, centerY), nil
+	case "center":
+		return image.Pt(centerX, centerY), nil
+	case "right":
+		return image.Pt(right, centerY), nil
+	case "bottom-left":
+		return image.Pt(left, bottom), nil
+	case "bottom":
+		return image.Pt(centerX, bottom), nil
+	case "bottom-right":
+		return image.Pt(right, bottom), nil
+	}
+	return image.Point{}, fmt.Errorf("unknown anchor %q", anchor)
+}
+
+// runOverlay applies an "overlay" entry from effects.txt. Accepted arguments:
+//
+//	src      path of the image to stamp, relative to img.Dir
+//	anchor   top-left (default), top, top-right, left, center, right,
+//	         bottom-left, bottom or bottom-right
+//	x, y     margin from the anchor in pixels, towards the centre
+//	opacity  multiplier for the overlay's alpha in [0, 1] (default 1)
+//	mode     normal (default), multiply, screen, overlay, darken, lighten
+//	         or difference
+//	op       Porter-Duff operator: over (default), atop, in, out, xor or
+//	         dest-over
+func (img *Image) runOverlay(spec effectSpec) error {
+	path := spec.str("src", "")
+	if path == "" {
+		return fmt.Errorf("overlay: needs src")
+	}
+	top, err := LoadOverlay(img.resolve(path))
+	if err != nil {
+		return fmt.Errorf("overlay: %v", err)
+	}
+	dx, err := spec.int("x", 0)
+	if err != nil {
+		return err
+	}
+	dy, err := spec.int("y", 0)
+	if err != nil {
+		return err
+	}
+	at, err := anchorPoint(spec.str("anchor", "top-left"), img.in.Bounds(), top.Bounds().Size(), dx, dy)
+	if err != nil {
+		return fmt.Errorf("overlay: %v", err)
+	}
+	opacity, err := spec.float("opacity", 1)
+	if err != nil {
+		return err
+	}
+	if opacity < 0 || opacity > 1 {
+		return fmt.Errorf("overlay: opacity must be in [0, 1]")
+	}
+	mode, err := ParseBlendMode(spec.str("mode", "normal"))
+	if err != nil {
+		return fmt.Errorf("overlay: %v", err)
+	}
+	op, err := ParseOperator(spec.str("op", "over"))
+	if err != nil {
+		return fmt.Errorf("overlay: %v", err)
+	}
+	img.Composite(top, at, opacity, mode, op)
+	return nil
+}
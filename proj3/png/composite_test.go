@@ -0,0 +1,148 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	stdpng "image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// premul returns the straight colour (r, g, b) with alpha a, premultiplied.
+func premul(r, g, b, a float64) color.RGBA64 {
+	c := func(v float64) uint16 { return uint16(math.Round(v * 65535)) }
+	return color.RGBA64{c(r * a), c(g * a), c(b * a), c(a)}
+}
+
+// checkPixel compares got with the premultiplied colour want, up to
+// rounding.
+func checkPixel(t *testing.T, name string, got color.RGBA64, want [4]float64) {
+	t.Helper()
+	for i, v := range []uint16{got.R, got.G, got.B, got.A} {
+		if w := uint32(math.Round(want[i] * 65535)); absDiff(uint32(v), w) > 2 {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+			return
+		}
+	}
+}
+
+// The expected results below follow the W3C Compositing and Blending spec:
+// a backdrop (0.2, 0.7, 0.5) under a source (0.6, 0.6, 0.3), premultiplied.
+
+func TestBlendModesW3C(t *testing.T) {
+	for _, test := range []struct {
+		mode   string
+		opaque [3]float64 // B(Cb, Cs) of both colours opaque
+		mixed  [3]float64 // source-over of a source at alpha 0.6 on a backdrop at 0.4
+	}{
+		{"normal", [3]float64{0.6, 0.6, 0.3}, [3]float64{0.392, 0.472, 0.26}},
+		{"multiply", [3]float64{0.12, 0.42, 0.15}, [3]float64{0.2768, 0.4288, 0.224}},
+		{"screen", [3]float64{0.68, 0.88, 0.65}, [3]float64{0.4112, 0.5392, 0.344}},
+		{"overlay", [3]float64{0.24, 0.76, 0.3}, [3]float64{0.3056, 0.5104, 0.26}},
+		{"darken", [3]float64{0.2, 0.6, 0.3}, [3]float64{0.296, 0.472, 0.26}},
+		{"lighten", [3]float64{0.6, 0.7, 0.5}, [3]float64{0.392, 0.496, 0.308}},
+		{"difference", [3]float64{0.4, 0.1, 0.2}, [3]float64{0.344, 0.352, 0.236}},
+	} {
+		mode, err := ParseBlendMode(test.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := compositePixel(premul(0.2, 0.7, 0.5, 1), premul(0.6, 0.6, 0.3, 1), 1, mode, Over)
+		checkPixel(t, test.mode+" opaque", got, [4]float64{test.opaque[0], test.opaque[1], test.opaque[2], 1})
+		got = compositePixel(premul(0.2, 0.7, 0.5, 0.4), premul(0.6, 0.6, 0.3, 0.6), 1, mode, Over)
+		checkPixel(t, test.mode+" translucent", got, [4]float64{test.mixed[0], test.mixed[1], test.mixed[2], 0.76})
+	}
+}
+
+func TestOperatorsW3C(t *testing.T) {
+	backdrop, source := premul(0.2, 0.7, 0.5, 0.4), premul(0.6, 0.6, 0.3, 0.6)
+	for _, test := range []struct {
+		op   string
+		want [4]float64
+	}{
+		{"over", [4]float64{0.392, 0.472, 0.26, 0.76}},
+		{"atop", [4]float64{0.176, 0.256, 0.152, 0.4}},
+		{"in", [4]float64{0.144, 0.144, 0.072, 0.24}},
+		{"out", [4]float64{0.216, 0.216, 0.108, 0.36}},
+		{"xor", [4]float64{0.248, 0.328, 0.188, 0.52}},
+		{"dest-over", [4]float64{0.296, 0.496, 0.308, 0.76}},
+	} {
+		op, err := ParseOperator(test.op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkPixel(t, test.op, compositePixel(backdrop, source, 1, Normal, op), test.want)
+		// Opacity scales the source's alpha: an opaque source at 0.6
+		// opacity gives the same result.
+		checkPixel(t, test.op+" opacity", compositePixel(backdrop, premul(0.6, 0.6, 0.3, 1), 0.6, Normal, op), test.want)
+	}
+}
+
+func TestCompositeOutsideOverlay(t *testing.T) {
+	top := image.NewRGBA64(image.Rect(0, 0, 2, 2))
+	for i := range top.Pix {
+		top.Pix[i] = 0xff
+	}
+	backdrop := color.RGBA64{0x4000, 0x8000, 0x2000, 0x8000}
+	for _, test := range []struct {
+		op      Operator
+		outside color.RGBA64
+	}{
+		{Over, backdrop}, {Atop, backdrop}, {Xor, backdrop}, {DestOver, backdrop},
+		{In, color.RGBA64{}}, {Out, color.RGBA64{}},
+	} {
+		src := image.NewRGBA64(image.Rect(0, 0, 4, 4))
+		draw.Draw(src, src.Bounds(), image.NewUniform(backdrop), image.Point{}, draw.Src)
+		img := newImage(src)
+		img.Composite(top, image.Pt(1, 1), 1, Normal, test.op)
+		if c := img.out.RGBA64At(0, 0); c != test.outside {
+			t.Errorf("op %d: pixel outside the overlay %v, want %v", test.op, c, test.outside)
+		}
+		want := compositePixel(backdrop, color.RGBA64{0xffff, 0xffff, 0xffff, 0xffff}, 1, Normal, test.op)
+		if c := img.out.RGBA64At(2, 2); c != want {
+			t.Errorf("op %d: pixel inside the overlay %v, want %v", test.op, c, want)
+		}
+	}
+}
+
+func TestLoadOverlayShared(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "stamp.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stdpng.Encode(f, solid(3, 2, color.White)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	first, err := LoadOverlay(filepath.Join(dir, "stamp.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := LoadOverlay(filepath.Join(dir, "stamp.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Error("the overlay was decoded twice")
+	}
+
+	// A relative src is resolved against the image's Dir, not the working
+	// directory.
+	img := newImage(solid(4, 4, color.Black))
+	if err := img.applyEffect("overlay:src=stamp.png"); err == nil {
+		t.Error("relative src found without a Dir")
+	}
+	img = newImage(solid(4, 4, color.Black))
+	img.Dir = dir
+	if err := img.applyEffect("overlay:src=stamp.png"); err != nil {
+		t.Fatal(err)
+	}
+	if c := img.out.RGBA64At(2, 1); c != (color.RGBA64{0xffff, 0xffff, 0xffff, 0xffff}) {
+		t.Errorf("stamped pixel %v, want white", c)
+	}
+}
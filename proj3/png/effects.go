@@ -3,7 +3,9 @@
 package png
 
 import (
-	"image/color" 
+	"fmt"
+	"math"
+	"time"
 )
 
 // Grayscale applies a grayscale filtering effect to the image
@@ -14,57 +16,92 @@ func (img *Image) Grayscale() {
 	// and height for the image
 	bounds := img.out.Bounds()
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			//Returns the pixel (i.e., RGBA) value at a (x,y) position
-			// Note: These get returned as int32 so based on the math you'll
-			// be performing you'll need to do a conversion to float64(..)
-			r, g, b, a := img.in.At(x, y).RGBA()
+		i := img.in.PixOffset(bounds.Min.X, y)
+		o := img.out.PixOffset(bounds.Min.X, y)
+		for x := bounds.Min.X; x < bounds.Max.X; x, i, o = x+1, i+8, o+8 {
+			//Reads the pixel (i.e., RGBA) value at a (x,y) position straight
+			// from the Pix slice
+			r, g, b, a := getPix(img.in.Pix, i)
 
 			//Note: The values for r,g,b,a for this assignment will range between [0, 65535].
 			//For certain computations (i.e., convolution) the values might fall outside this
 			// range so you need to clamp them between those values.
-			greyC := clamp(float64(r+g+b) / 3)
+			greyC := uint16((uint32(r) + uint32(g) + uint32(b)) / 3)
 
 			//Note: The values need to be stored back as uint16 (I know weird..but there's valid reasons
 			// for this that I won't get into right now).
-			img.out.Set(x, y, color.RGBA64{greyC, greyC, greyC, uint16(a)})
+			setPix(img.out.Pix, o, greyC, greyC, greyC, a)
 		}
 	}
 }
 
+// Convolution applies a 3x3 kernel to every channel of the image, alpha
+// included. Pixels are premultiplied, so fully transparent neighbours add
+// neither colour nor coverage and their hidden colour cannot bleed into the
+// result.
+//
+// Pixels beyond the border repeat the nearest edge pixel. The effects used
+// to pad with zeros, which made blur darken and sharpen brighten the border
+// of every image; with alpha convolved too, zeros would also fade it.
+//
+// Kernels whose weights don't sum to 1 (e.g. edge detection) would fade a
+// uniform alpha, so for those the alpha of the centre pixel is kept.
 func (img *Image) Convolution(kernel []float64) {
-    bounds := img.out.Bounds()
-    kernelSize := 3
-    padding := 1
-    var r, g, b, a uint32
-    var rSum, gSum, bSum float64
+	bounds := img.out.Bounds()
+	kernelSize := 3
+	padding := 1
+	var rSum, gSum, bSum, aSum float64
+
+	kernelTotal := 0.0
+	for _, k := range kernel {
+		kernelTotal += k
+	}
+	convolveAlpha := math.Abs(kernelTotal-1) < 1e-9
+
+	// Byte offsets of the clamped neighbour rows and columns, so the inner
+	// loop reads img.in.Pix directly.
+	rowOffsets := make([]int, bounds.Dy()+2*padding)
+	for k := range rowOffsets {
+		y := clampIndex(bounds.Min.Y+k-padding, bounds.Min.Y, bounds.Max.Y)
+		rowOffsets[k] = (y - bounds.Min.Y) * img.in.Stride
+	}
+	colOffsets := make([]int, bounds.Dx()+2*padding)
+	for k := range colOffsets {
+		colOffsets[k] = clampIndex(k-padding, 0, bounds.Dx()) * 8
+	}
 
 	// Steps :
 	// 1. Iterate over (y, x) Image dimensions, (ky, kx) Kernel dimensions
-	// 2. Perform same padding convolution
+	// 2. Perform same size convolution, repeating the edge pixels
 	// 3. Write to Image Out
-    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X; x++ {
-            rSum, gSum, bSum = 0, 0, 0
-
-            for ky := -padding; ky <= padding; ky++ {
-                for kx := -padding; kx <= padding; kx++ {
-                    imgX := x + kx
-                    imgY := y + ky
-
-                    if imgX >= bounds.Min.X && imgX < bounds.Max.X && imgY >= bounds.Min.Y && imgY < bounds.Max.Y {
-                        r, g, b, a = img.in.At(imgX, imgY).RGBA()
-                        kernelValue := kernel[(ky+padding)*kernelSize+(kx+padding)]
-                        rSum += float64(r) * kernelValue
-                        gSum += float64(g) * kernelValue
-                        bSum += float64(b) * kernelValue
-                    }
-                }
-            }
-
-            img.out.Set(x, y, color.RGBA64{clamp(rSum), clamp(gSum), clamp(bSum), uint16(a)})
-        }
-    }
+	for y := 0; y < bounds.Dy(); y++ {
+		o := img.out.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+		for x := 0; x < bounds.Dx(); x, o = x+1, o+8 {
+			rSum, gSum, bSum, aSum = 0, 0, 0, 0
+
+			for ky := 0; ky < kernelSize; ky++ {
+				row := rowOffsets[y+ky]
+				for kx := 0; kx < kernelSize; kx++ {
+					kernelValue := kernel[ky*kernelSize+kx]
+					if kernelValue == 0 {
+						continue
+					}
+					r, g, b, a := getPix(img.in.Pix, row+colOffsets[x+kx])
+					rSum += float64(r) * kernelValue
+					gSum += float64(g) * kernelValue
+					bSum += float64(b) * kernelValue
+					aSum += float64(a) * kernelValue
+				}
+			}
+
+			_, _, _, a := getPix(img.in.Pix, rowOffsets[y+padding]+colOffsets[x+padding])
+			if convolveAlpha {
+				a = clamp(math.Round(aSum))
+			}
+			// Premultiplied colour can never exceed its alpha.
+			setPix(img.out.Pix, o, clampTo(rSum, a), clampTo(gSum, a), clampTo(bSum, a), a)
+		}
+	}
 }
 
 
@@ -86,25 +123,111 @@ func (img *Image) Blur() {
 }
 
 
+// RunEffects applies each entry of effects in order, feeding the output of one
+// effect into the next. Entries are either one of the one letter effects or a
+// named effect with arguments (see effectSpec).
 func (img *Image) RunEffects(effects []string){
 	// Steps: 
 	// 1. Iterate over Effects
 	// 2. Execute effect using Convolution
 	// 3. Image in  = Previous Image out
+	if len(effects) == 0 {
+		img.pool.Put(img.out)
+		img.out = img.in
+		return
+	}
+	linear := false
 	for i := 0; i < len(effects); i++ {
-		effect := effects[i]
-		if (effect == "S") {
-			img.Sharpen()
-		} else if (effect == "E") {
-			img.EdgeDetection()
-		} else if (effect == "B") {
-			img.Blur()
-		} else if (effect == "G") {
-			img.Grayscale()
-		} else {
-			panic("Incorrect Effect")
+		if i > 0 {
+			img.advance()
+		}
+		if img.LinearLight && wantsLinear(effects[i], linear) != linear {
+			linear = !linear
+			img.convertInput(linear)
+		}
+		start := time.Now()
+		if err := img.applyEffect(effects[i]); err != nil {
+			panic("Incorrect Effect: " + err.Error())
 		}
+		if img.EffectDone != nil {
+			img.EffectDone(effects[i], start)
+		}
+	}
+	if linear {
+		convertBuffer(img.out, sRGBLUT())
+	}
+}
+
+// applyEffect runs a single effects.txt entry from img.in into img.out.
+// Any entry, the one letter ones included, can be limited to part of the
+// image with the arguments of regionMask, e.g. "B:roi=10 20 64 32".
+func (img *Image) applyEffect(effect string) error {
+	if effect == "S" {
+		img.Sharpen()
+		return nil
+	} else if effect == "E" {
+		img.EdgeDetection()
+		return nil
+	} else if effect == "B" {
+		img.Blur()
+		return nil
+	} else if effect == "G" {
+		img.Grayscale()
+		return nil
+	}
+
+	spec, err := parseEffect(effect)
+	if err != nil {
+		return err
+	}
+	mask, err := img.regionMask(spec)
+	if err != nil {
+		return err
+	}
+	if err := img.runSpec(spec); err != nil {
+		return err
+	}
+	if mask != nil {
+		if img.out.Bounds() != img.in.Bounds() {
+			return fmt.Errorf("effect %q changes the size of the image, so it can't be limited to a region", spec.name)
+		}
+		img.Restrict(mask)
+	}
+	return nil
+}
 
-		img.in = img.out
+// runSpec runs the effect named by spec.
+func (img *Image) runSpec(spec effectSpec) error {
+	switch spec.name {
+	case "S", "E", "B", "G":
+		if len(spec.args) > 0 {
+			return fmt.Errorf("effect %q takes no arguments besides roi, feather and mask", spec.name)
+		}
+		return img.applyEffect(spec.name)
+	case "resize":
+		return img.runResize(spec)
+	case "rotate":
+		return img.runRotate(spec)
+	case "flip":
+		return img.runFlip(spec)
+	case "transpose":
+		img.Transpose()
+	case "crop":
+		return img.runCrop(spec)
+	case "affine", "perspective":
+		return img.runWarp(spec)
+	case "equalize":
+		img.Equalize()
+	case "clahe":
+		return img.runCLAHE(spec)
+	case "brightness", "contrast", "gamma", "levels", "curves":
+		return img.runAdjust(spec)
+	case "hue", "saturation", "vibrance", "lightness", "grayscale":
+		return img.runColor(spec)
+	case "overlay":
+		return img.runOverlay(spec)
+	default:
+		return fmt.Errorf("unknown effect %q", spec.name)
 	}
-}
\ No newline at end of file
+	return nil
+}
@@ -0,0 +1,124 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Golden regression tests for every effect. Each effect runs on small
+// synthetic images chosen to exercise it: a colour gradient with an alpha
+// ramp, a checkerboard with hard edges and a single bright pixel whose
+// output shows the effect's kernel. Run go test -update after an intended
+// change of output and review the new images in testdata/golden.
+
+// goldenSources returns the synthetic inputs by name.
+func goldenSources() map[string]image.Image {
+	gradient := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	checker := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	impulse := image.NewGray(image.Rect(0, 0, 15, 15))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{uint8(x * 17), uint8(y * 17), uint8((x + y) * 8), uint8(255 - y*8)})
+			v := uint8(0x20)
+			if (x/4+y/4)%2 == 0 {
+				v = 0xe0
+			}
+			checker.SetRGBA(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	impulse.SetGray(7, 7, color.Gray{255})
+	return map[string]image.Image{"gradient": gradient, "checker": checker, "impulse": impulse}
+}
+
+// goldenEffects lists an effect chain for every effect and option worth
+// pinning down, keyed by the golden name.
+var goldenEffects = []struct {
+	name    string
+	effects []string
+}{
+	{"grayscale", []string{"G"}},
+	{"sharpen", []string{"S"}},
+	{"edges", []string{"E"}},
+	{"blur", []string{"B"}},
+	{"resize_nearest", []string{"resize:w=23,h=11,filter=nearest"}},
+	{"resize_bilinear", []string{"resize:w=23,h=11,filter=bilinear"}},
+	{"resize_bicubic", []string{"resize:w=23,h=11"}},
+	{"resize_lanczos", []string{"resize:w=7,h=9,filter=lanczos"}},
+	{"resize_scale", []string{"resize:scale=0.5"}},
+	{"resize_fit", []string{"resize:w=12,h=6,mode=fit"}},
+	{"resize_fill", []string{"resize:w=12,h=6,mode=fill"}},
+	{"rotate90", []string{"rotate:deg=90"}},
+	{"rotate180", []string{"rotate:deg=180"}},
+	{"rotate270", []string{"rotate:deg=270"}},
+	{"rotate30", []string{"rotate:deg=30,bg=203040"}},
+	{"rotate_bilinear", []string{"rotate:deg=-15,filter=bilinear"}},
+	{"flip_h", []string{"flip:dir=h"}},
+	{"flip_v", []string{"flip:dir=v"}},
+	{"transpose", []string{"transpose"}},
+	{"crop", []string{"crop:x=3,y=2,w=9,h=7"}},
+	{"affine", []string{"affine:m=0.9 0.3 1 -0.2 1.1 2"}},
+	{"perspective", []string{"perspective:src=2 1 14 3 13 14 1 12,w=12,h=12"}},
+	{"equalize", []string{"equalize"}},
+	{"clahe", []string{"clahe:tile=8,clip=1.5"}},
+	{"brightness", []string{"brightness:v=0.2"}},
+	{"contrast", []string{"contrast:v=0.4"}},
+	{"gamma", []string{"gamma:v=2.2"}},
+	{"levels", []string{"levels:black=0.1,white=0.8,mid=1.3,outblack=0.05"}},
+	{"curves", []string{"curves:points=0 0 0.3 0.15 0.7 0.85 1 1"}},
+	{"hue", []string{"hue:deg=120"}},
+	{"saturation", []string{"saturation:v=1.8"}},
+	{"vibrance", []string{"vibrance:v=0.6"}},
+	{"lightness", []string{"lightness:v=-15"}},
+	{"grayscale_rec709", []string{"grayscale:method=rec709"}},
+	{"grayscale_rec601", []string{"grayscale:method=rec601"}},
+	{"grayscale_lab", []string{"grayscale:method=lab"}},
+	{"overlay", []string{"overlay:src=testdata/stamp.png,anchor=center"}},
+	{"overlay_multiply", []string{"overlay:src=testdata/stamp.png,anchor=bottom-right,x=1,y=2,mode=multiply,opacity=0.7"}},
+	{"overlay_screen_atop", []string{"overlay:src=testdata/stamp.png,mode=screen,op=atop"}},
+	{"overlay_xor", []string{"overlay:src=testdata/stamp.png,anchor=right,op=xor"}},
+	{"roi_blur", []string{"B:roi=3 4 8 6,feather=2"}},
+	{"mask_sharpen", []string{"S:mask=testdata/mask.png"}},
+
+	{"chain_gray_blur_sharpen", []string{"G", "B", "S"}},
+	{"chain_resize_sharpen", []string{"resize:w=10,filter=lanczos", "S"}},
+	{"chain_rotate_crop", []string{"rotate:deg=45", "crop:x=4,y=4,w=12,h=12"}},
+	{"chain_tone_colour_blur", []string{"contrast:v=0.3", "hue:deg=-40", "B"}},
+	{"chain_edges_levels", []string{"E", "levels:white=0.5"}},
+	{"chain_blur_blur_edges", []string{"B", "B", "E"}},
+}
+
+func TestEffectsGolden(t *testing.T) {
+	sources := goldenSources()
+	for _, test := range goldenEffects {
+		for name, src := range sources {
+			golden := "effect_" + test.name + "_" + name
+			t.Run(golden, func(t *testing.T) {
+				checkGolden(t, golden, runOn(src, false, test.effects...))
+			})
+		}
+	}
+}
+
+// TestEffectsGoldenCoverage checks that every effect has a golden test.
+func TestEffectsGoldenCoverage(t *testing.T) {
+	covered := map[string]bool{}
+	for _, test := range goldenEffects {
+		for _, effect := range test.effects {
+			spec, err := parseEffect(effect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			covered[spec.name] = true
+		}
+	}
+	for _, name := range []string{
+		"G", "S", "E", "B", "resize", "rotate", "flip", "transpose", "crop", "affine",
+		"perspective", "equalize", "clahe", "brightness", "contrast", "gamma", "levels",
+		"curves", "hue", "saturation", "vibrance", "lightness", "grayscale", "overlay",
+	} {
+		if !covered[name] {
+			t.Errorf("effect %q has no golden test", name)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package png
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// Importing the encoders above also registers their decoders with the image
+// package, which is what lets Load sniff the format of its input.
+
+// SaveOptions tunes the encoder chosen by SaveWith. The zero value gives the
+// defaults of each encoder.
+type SaveOptions struct {
+	// Quality is the JPEG quality from 1 to 100 (default 90).
+	Quality int `json:"quality"`
+
+	// Compression is the PNG compression level: "default", "none", "fast"
+	// or "best".
+	Compression string `json:"compression"`
+
+	// BitDepth forces 8 or 16 bits per channel. By default images are
+	// written with 16 bits, the precision effects run at.
+	BitDepth int `json:"bitDepth"`
+
+	// Palette writes a paletted image when it has at most 256 colours.
+	Palette bool `json:"palette"`
+
+	// Preserve writes the image in the colour model it was loaded with
+	// (gray, 8-bit, 16-bit or paletted) unless the effects made that
+	// impossible, e.g. by adding colour to a gray image. BitDepth still
+	// overrides the depth.
+	Preserve bool `json:"preserve"`
+}
+
+var compressionLevels = map[string]png.CompressionLevel{
+	"":        png.DefaultCompression,
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// defaultJPEGQuality is used when SaveOptions.Quality is not set.
+const defaultJPEGQuality = 90
+
+// encoderFor returns the function writing an image in the format given by
+// the extension of filePath.
+func encoderFor(filePath string, opts SaveOptions) (func(io.Writer, image.Image) error, error) {
+	if opts.BitDepth != 0 && opts.BitDepth != 8 && opts.BitDepth != 16 {
+		return nil, fmt.Errorf("bit depth %d is not 8 or 16", opts.BitDepth)
+	}
+	switch ext := strings.ToLower(filepath.Ext(filePath)); ext {
+	case ".png":
+		level, ok := compressionLevels[opts.Compression]
+		if !ok {
+			return nil, fmt.Errorf("unknown PNG compression %q", opts.Compression)
+		}
+		encoder := &png.Encoder{CompressionLevel: level}
+		return encoder.Encode, nil
+	case ".jpg", ".jpeg":
+		quality := opts.Quality
+		if quality == 0 {
+			quality = defaultJPEGQuality
+		}
+		if quality < 1 || quality > 100 {
+			return nil, fmt.Errorf("JPEG quality %d is not in [1, 100]", quality)
+		}
+		return func(w io.Writer, m image.Image) error {
+			return jpeg.Encode(w, m, &jpeg.Options{Quality: quality})
+		}, nil
+	case ".gif":
+		return func(w io.Writer, m image.Image) error {
+			// Images with few colours are written losslessly; others are
+			// dithered to the encoder's default palette.
+			if paletted, ok := toPaletted(m, 256); ok {
+				return gif.Encode(w, paletted, nil)
+			}
+			return gif.Encode(w, m, nil)
+		}, nil
+	case ".bmp":
+		return bmp.Encode, nil
+	case ".tif", ".tiff":
+		return func(w io.Writer, m image.Image) error {
+			return tiff.Encode(w, m, &tiff.Options{Compression: tiff.Deflate})
+		}, nil
+	default:
+		return nil, fmt.Errorf("no encoder for %q files", ext)
+	}
+}
+
+// toPaletted returns m as a paletted image if it has at most maxColors
+// distinct colours.
+func toPaletted(m image.Image, maxColors int) (*image.Paletted, bool) {
+	bounds := m.Bounds()
+	index := map[color.NRGBA64]uint8{}
+	var palette color.Palette
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(m.At(x, y)).(color.NRGBA64)
+			if _, ok := index[c]; ok {
+				continue
+			}
+			if len(palette) == maxColors {
+				return nil, false
+			}
+			index[c] = uint8(len(palette))
+			palette = append(palette, c)
+		}
+	}
+
+	paletted := image.NewPaletted(bounds, palette)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(m.At(x, y)).(color.NRGBA64)
+			paletted.SetColorIndex(x, y, index[c])
+		}
+	}
+	return paletted, true
+}
+
+// encodable converts the output buffer into the image handed to the
+// encoder, following the Palette, Preserve and BitDepth options. The
+// encoders pick their pixel format from the concrete image type, e.g. an
+// *image.Gray becomes an 8-bit grayscale PNG.
+func (img *Image) encodable(opts SaveOptions) image.Image {
+	if opts.Palette {
+		if paletted, ok := toPaletted(img.out, 256); ok {
+			return paletted
+		}
+	}
+
+	depth := 16
+	if opts.Preserve {
+		switch model := img.sourceModel.(type) {
+		case color.Palette:
+			// Effects may have changed the colours, so build a new palette.
+			if paletted, ok := toPaletted(img.out, 256); ok && opts.BitDepth == 0 {
+				return paletted
+			}
+			depth = 8
+		default:
+			if model == color.GrayModel || model == color.Gray16Model {
+				wide := model == color.Gray16Model
+				if opts.BitDepth != 0 {
+					wide = opts.BitDepth == 16
+				}
+				if gray, ok := toGray(img.out, wide); ok {
+					return gray
+				}
+			}
+			switch model {
+			case color.GrayModel, color.NRGBAModel, color.RGBAModel, color.YCbCrModel, color.CMYKModel:
+				depth = 8
+			}
+		}
+	}
+	if opts.BitDepth != 0 {
+		depth = opts.BitDepth
+	}
+
+	if depth == 8 {
+		bounds := img.out.Bounds()
+		nrgba := image.NewNRGBA(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				nrgba.Set(x, y, img.out.RGBA64At(x, y))
+			}
+		}
+		return nrgba
+	}
+	return img.out
+}
+
+// toGray returns m as an 8-bit (or, if wide, 16-bit) grayscale image if
+// every pixel of m is an opaque gray.
+func toGray(m *image.RGBA64, wide bool) (image.Image, bool) {
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := m.RGBA64At(x, y)
+			if c.A != 0xffff || c.R != c.G || c.G != c.B {
+				return nil, false
+			}
+		}
+	}
+
+	var gray interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	if wide {
+		gray = image.NewGray16(bounds)
+	} else {
+		gray = image.NewGray(bounds)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray.Set(x, y, color.Gray16{m.RGBA64At(x, y).R})
+		}
+	}
+	return gray, true
+}
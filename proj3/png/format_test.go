@@ -0,0 +1,153 @@
+package png
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		file   string
+		format string
+		exact  bool
+	}{
+		{"out.png", "png", true},
+		{"out.jpg", "jpeg", false},
+		{"out.jpeg", "jpeg", false},
+		{"out.gif", "gif", false},
+		{"out.bmp", "bmp", true},
+		{"out.tiff", "tiff", true},
+	}
+	src := edgeImage(color.NRGBA{200, 100, 50, 255})
+	for _, test := range tests {
+		img := newImage(src)
+		img.RunEffects(nil)
+		path := filepath.Join(dir, test.file)
+		if err := img.SaveWith(path, SaveOptions{Quality: 95}); err != nil {
+			t.Fatalf("%s: %v", test.file, err)
+		}
+		loaded, err := Load(path)
+		if err != nil {
+			t.Fatalf("%s: %v", test.file, err)
+		}
+		if loaded.Format != test.format {
+			t.Errorf("%s: sniffed format %q, want %q", test.file, loaded.Format, test.format)
+		}
+		if loaded.Bounds != img.Bounds {
+			t.Errorf("%s: bounds %v, want %v", test.file, loaded.Bounds, img.Bounds)
+		}
+		want, got := img.out.RGBA64At(12, 4), loaded.in.RGBA64At(12, 4)
+		tolerance := uint32(0x0101)
+		if !test.exact {
+			tolerance = 0x1000
+		}
+		if absDiff(uint32(want.R), uint32(got.R)) > tolerance || absDiff(uint32(want.G), uint32(got.G)) > tolerance {
+			t.Errorf("%s: pixel %v, want %v", test.file, got, want)
+		}
+	}
+}
+
+func TestSaveUnknownExtension(t *testing.T) {
+	img := newImage(edgeImage(color.White))
+	img.RunEffects(nil)
+	if err := img.Save(filepath.Join(t.TempDir(), "out.webp")); err == nil {
+		t.Error("saving to .webp succeeded, want an error")
+	}
+	if err := img.SaveWith(filepath.Join(t.TempDir(), "out.jpg"), SaveOptions{Quality: 101}); err == nil {
+		t.Error("JPEG quality 101 accepted, want an error")
+	}
+}
+
+// saveAndDecode saves img with opts as PNG and returns the decoded file and
+// its size in bytes.
+func saveAndDecode(t *testing.T, img *Image, opts SaveOptions) (image.Image, int64) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "out.png")
+	if err := img.SaveWith(path, opts); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, info.Size()
+}
+
+func TestSaveOptions(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i)
+	}
+	tests := []struct {
+		name    string
+		src     image.Image
+		effects []string
+		opts    SaveOptions
+		want    string
+	}{
+		{"default is 16-bit", gray, nil, SaveOptions{}, "*image.RGBA64"},
+		{"8-bit", gray, nil, SaveOptions{BitDepth: 8}, "*image.RGBA"},
+		{"preserve gray", gray, []string{"B"}, SaveOptions{Preserve: true}, "*image.Gray"},
+		{"preserve gray at 16 bits", gray, nil, SaveOptions{Preserve: true, BitDepth: 16}, "*image.Gray16"},
+		{"gray made colourful", gray, []string{"overlay:src=testdata/red.png"}, SaveOptions{Preserve: true}, "*image.RGBA"},
+		{"preserve 8-bit colour", edgeImage(color.NRGBA{200, 100, 50, 255}), []string{"B"}, SaveOptions{Preserve: true}, "*image.RGBA"},
+		{"palette", edgeImage(color.NRGBA{200, 100, 50, 255}), nil, SaveOptions{Palette: true}, "*image.Paletted"},
+		{"palette with too many colours", gradient(), nil, SaveOptions{Palette: true}, "*image.RGBA64"},
+		{"preserve paletted", paletted(), []string{"flip"}, SaveOptions{Preserve: true}, "*image.Paletted"},
+	}
+	for _, test := range tests {
+		img := newImage(test.src)
+		img.RunEffects(test.effects)
+		m, _ := saveAndDecode(t, img, test.opts)
+		if got := fmt.Sprintf("%T", m); got != test.want {
+			t.Errorf("%s: decoded as %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSaveCompression(t *testing.T) {
+	img := newImage(edgeImage(color.White))
+	img.RunEffects(nil)
+	_, none := saveAndDecode(t, img, SaveOptions{Compression: "none"})
+	_, best := saveAndDecode(t, img, SaveOptions{Compression: "best"})
+	if best >= none {
+		t.Errorf("best compression wrote %d bytes, no compression %d", best, none)
+	}
+	if err := img.SaveWith(filepath.Join(t.TempDir(), "out.png"), SaveOptions{Compression: "max"}); err == nil {
+		t.Error("unknown compression accepted, want an error")
+	}
+}
+
+// gradient returns a 20x20 image with 400 distinct colours.
+func gradient() image.Image {
+	m := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			m.Set(x, y, color.RGBA{uint8(x * 12), uint8(y * 12), 0, 255})
+		}
+	}
+	return m
+}
+
+func paletted() image.Image {
+	m := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White, color.NRGBA{255, 0, 0, 255}})
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i % 3)
+	}
+	return m
+}
@@ -0,0 +1,304 @@
+package png
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// remap fills a new width x height output buffer where output pixel (x, y)
+// is copied from input pixel src(x, y), and updates Bounds. It is used by the
+// lossless transforms (right angle rotations, flips and transpose).
+func (img *Image) remap(width, height int, src func(x, y int) (int, int)) {
+	bounds := image.Rect(0, 0, width, height)
+	out := img.replaceOut(bounds)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy := src(x, y)
+			out.SetRGBA64(x, y, img.in.RGBA64At(sx, sy))
+		}
+	}
+}
+
+// Rotate90 rotates the image 90 degrees clockwise.
+func (img *Image) Rotate90() {
+	b := img.in.Bounds()
+	img.remap(b.Dy(), b.Dx(), func(x, y int) (int, int) {
+		return b.Min.X + y, b.Max.Y - 1 - x
+	})
+}
+
+// Rotate180 rotates the image 180 degrees.
+func (img *Image) Rotate180() {
+	b := img.in.Bounds()
+	img.remap(b.Dx(), b.Dy(), func(x, y int) (int, int) {
+		return b.Max.X - 1 - x, b.Max.Y - 1 - y
+	})
+}
+
+// Rotate270 rotates the image 270 degrees clockwise (90 counter-clockwise).
+func (img *Image) Rotate270() {
+	b := img.in.Bounds()
+	img.remap(b.Dy(), b.Dx(), func(x, y int) (int, int) {
+		return b.Max.X - 1 - y, b.Min.Y + x
+	})
+}
+
+// FlipHorizontal mirrors the image left to right.
+func (img *Image) FlipHorizontal() {
+	b := img.in.Bounds()
+	img.remap(b.Dx(), b.Dy(), func(x, y int) (int, int) {
+		return b.Max.X - 1 - x, b.Min.Y + y
+	})
+}
+
+// FlipVertical mirrors the image top to bottom.
+func (img *Image) FlipVertical() {
+	b := img.in.Bounds()
+	img.remap(b.Dx(), b.Dy(), func(x, y int) (int, int) {
+		return b.Min.X + x, b.Max.Y - 1 - y
+	})
+}
+
+// Transpose mirrors the image along its main diagonal.
+func (img *Image) Transpose() {
+	b := img.in.Bounds()
+	img.remap(b.Dy(), b.Dx(), func(x, y int) (int, int) {
+		return b.Min.X + y, b.Min.Y + x
+	})
+}
+
+// Crop keeps only the part of the image inside rect, which is clipped to
+// the image bounds. The cropped image starts at (0, 0).
+func (img *Image) Crop(rect image.Rectangle) error {
+	rect, err := clipCrop(rect, img.in.Bounds())
+	if err != nil {
+		return err
+	}
+	img.remap(rect.Dx(), rect.Dy(), func(x, y int) (int, int) {
+		return rect.Min.X + x, rect.Min.Y + y
+	})
+	return nil
+}
+
+// clipCrop moves rect, given relative to the top left corner of bounds, onto
+// bounds and clips it to them.
+func clipCrop(rect, bounds image.Rectangle) (image.Rectangle, error) {
+	rect = rect.Add(bounds.Min).Intersect(bounds)
+	if rect.Empty() {
+		return rect, fmt.Errorf("crop rectangle lies outside the %dx%d image", bounds.Dx(), bounds.Dy())
+	}
+	return rect, nil
+}
+
+// Rotate rotates the image clockwise by degrees. Multiples of 90 degrees are
+// handled exactly; any other angle grows the output to the bounding box of
+// the rotated image, samples it with filter and fills the uncovered corners
+// with bg.
+func (img *Image) Rotate(degrees float64, bg color.RGBA64, filter Filter) {
+	degrees = normalDegrees(degrees)
+	switch degrees {
+	case 0:
+		b := img.in.Bounds()
+		img.remap(b.Dx(), b.Dy(), func(x, y int) (int, int) { return b.Min.X + x, b.Min.Y + y })
+		return
+	case 90:
+		img.Rotate90()
+		return
+	case 180:
+		img.Rotate180()
+		return
+	case 270:
+		img.Rotate270()
+		return
+	}
+
+	b := img.in.Bounds()
+	theta := degrees * math.Pi / 180
+	sin, cos := math.Sin(theta), math.Cos(theta)
+	srcW, srcH := float64(b.Dx()), float64(b.Dy())
+	width, height := rotatedSize(b.Dx(), b.Dy(), degrees)
+
+	// Inverse mapping: rotate each output pixel centre back by -theta about
+	// the centre of the image.
+	cx, cy := float64(width)/2, float64(height)/2
+	scx, scy := float64(b.Min.X)+srcW/2, float64(b.Min.Y)+srcH/2
+	img.warp(width, height, bg, filter, func(x, y float64) (float64, float64) {
+		dx, dy := x-cx, y-cy
+		return scx + dx*cos + dy*sin, scy - dx*sin + dy*cos
+	})
+}
+
+// normalDegrees returns degrees in [0, 360).
+func normalDegrees(degrees float64) float64 {
+	degrees = math.Mod(degrees, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+	return degrees
+}
+
+// rotationSize returns the size Rotate turns a width x height image into.
+func rotationSize(width, height int, degrees float64) (int, int) {
+	switch degrees = normalDegrees(degrees); degrees {
+	case 0, 180:
+		return width, height
+	case 90, 270:
+		return height, width
+	}
+	return rotatedSize(width, height, degrees)
+}
+
+// rotatedSize returns the size of the bounding box of a width x height
+// image rotated by degrees that aren't a multiple of 90.
+func rotatedSize(width, height int, degrees float64) (int, int) {
+	theta := degrees * math.Pi / 180
+	sin, cos := math.Abs(math.Sin(theta)), math.Abs(math.Cos(theta))
+	w, h := float64(width), float64(height)
+	return int(math.Ceil(w*cos + h*sin - 1e-9)), int(math.Ceil(w*sin + h*cos - 1e-9))
+}
+
+// warp fills a new width x height output buffer by mapping the centre of each
+// output pixel to a point in the input with inverse and sampling the input
+// there with filter. Points that fall outside the input get bg. Bounds is
+// updated.
+func (img *Image) warp(width, height int, bg color.RGBA64, filter Filter, inverse func(x, y float64) (float64, float64)) {
+	bounds := image.Rect(0, 0, width, height)
+	out := img.replaceOut(bounds)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy := inverse(float64(x)+0.5, float64(y)+0.5)
+			out.SetRGBA64(x, y, img.sample(sx, sy, bg, filter))
+		}
+	}
+}
+
+// sample interpolates the input image at the continuous position (fx, fy),
+// where pixel (x, y) covers [x, x+1) x [y, y+1). Positions outside the image
+// return bg. Lanczos is treated as bicubic since point sampling gains nothing
+// from the wider kernel.
+func (img *Image) sample(fx, fy float64, bg color.RGBA64, filter Filter) color.RGBA64 {
+	b := img.in.Bounds()
+	if fx < float64(b.Min.X) || fy < float64(b.Min.Y) || fx >= float64(b.Max.X) || fy >= float64(b.Max.Y) {
+		return bg
+	}
+	if filter == Nearest {
+		return img.in.RGBA64At(int(fx), int(fy))
+	}
+
+	// Shift to sample centres so integer positions hit pixel centres.
+	fx, fy = fx-0.5, fy-0.5
+	x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+	tx, ty := fx-float64(x0), fy-float64(y0)
+
+	var xs, ys []tap
+	if filter == Bilinear {
+		xs = []tap{{x0, 1 - tx}, {x0 + 1, tx}}
+		ys = []tap{{y0, 1 - ty}, {y0 + 1, ty}}
+	} else {
+		xs, ys = make([]tap, 4), make([]tap, 4)
+		for i := -1; i <= 2; i++ {
+			xs[i+1] = tap{x0 + i, Bicubic.weight(float64(i) - tx)}
+			ys[i+1] = tap{y0 + i, Bicubic.weight(float64(i) - ty)}
+		}
+	}
+
+	var r, g, bl, a float64
+	for _, ty := range ys {
+		sy := clampIndex(ty.index, b.Min.Y, b.Max.Y)
+		for _, tx := range xs {
+			w := tx.weight * ty.weight
+			c := img.in.RGBA64At(clampIndex(tx.index, b.Min.X, b.Max.X), sy)
+			r += float64(c.R) * w
+			g += float64(c.G) * w
+			bl += float64(c.B) * w
+			a += float64(c.A) * w
+		}
+	}
+	alpha := clamp(math.Round(a))
+	return color.RGBA64{clampTo(math.Round(r), alpha), clampTo(math.Round(g), alpha), clampTo(math.Round(bl), alpha), alpha}
+}
+
+// rotation is a parsed "rotate" entry.
+type rotation struct {
+	degrees float64
+	bg      color.RGBA64
+	filter  Filter
+}
+
+// parseRotate reads a "rotate" entry. Accepted arguments:
+//
+//	deg     clockwise angle in degrees (default 90)
+//	bg      fill colour for uncovered corners as rrggbb[aa] (default transparent)
+//	filter  nearest, bilinear (default) or bicubic
+func parseRotate(spec effectSpec) (rotation, error) {
+	var r rotation
+	var err error
+	if r.degrees, err = spec.float("deg", 90); err != nil {
+		return r, err
+	}
+	if r.bg, err = spec.color("bg", color.RGBA64{}); err != nil {
+		return r, err
+	}
+	r.filter, err = ParseFilter(spec.str("filter", "bilinear"))
+	return r, err
+}
+
+// runRotate applies a "rotate" entry from effects.txt (see parseRotate).
+func (img *Image) runRotate(spec effectSpec) error {
+	r, err := parseRotate(spec)
+	if err != nil {
+		return err
+	}
+	img.Rotate(r.degrees, r.bg, r.filter)
+	return nil
+}
+
+// runFlip applies a "flip" entry from effects.txt; dir is "h" (default) or "v".
+func (img *Image) runFlip(spec effectSpec) error {
+	switch dir := spec.str("dir", "h"); dir {
+	case "h":
+		img.FlipHorizontal()
+	case "v":
+		img.FlipVertical()
+	default:
+		return fmt.Errorf("flip: unknown direction %q", dir)
+	}
+	return nil
+}
+
+// parseCrop reads a "crop" entry for an input covering bounds and returns
+// the rectangle to keep, relative to the top left corner of bounds. x and y
+// default to 0 and w and h default to the rest of the image.
+func parseCrop(spec effectSpec, bounds image.Rectangle) (image.Rectangle, error) {
+	x, err := spec.int("x", 0)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	y, err := spec.int("y", 0)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	w, err := spec.int("w", bounds.Dx()-x)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	h, err := spec.int("h", bounds.Dy()-y)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	if w <= 0 || h <= 0 {
+		return image.Rectangle{}, fmt.Errorf("crop: w and h must be positive")
+	}
+	return image.Rect(x, y, x+w, y+h), nil
+}
+
+// runCrop applies a "crop" entry from effects.txt (see parseCrop).
+func (img *Image) runCrop(spec effectSpec) error {
+	rect, err := parseCrop(spec, img.in.Bounds())
+	if err != nil {
+		return err
+	}
+	return img.Crop(rect)
+}
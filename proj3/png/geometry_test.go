@@ -0,0 +1,85 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// numbered returns a width x height image whose pixel (x, y) has red x and
+// green y, so every pixel can be traced to where it came from.
+func numbered(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	return img
+}
+
+func TestRightAngleTransforms(t *testing.T) {
+	const width, height = 5, 3
+	src := numbered(width, height)
+	tests := []struct {
+		effect string
+		size   image.Point
+		// from returns the source pixel of output pixel (x, y).
+		from func(x, y int) (int, int)
+	}{
+		{"rotate:deg=90", image.Pt(height, width), func(x, y int) (int, int) { return y, height - 1 - x }},
+		{"rotate:deg=-270", image.Pt(height, width), func(x, y int) (int, int) { return y, height - 1 - x }},
+		{"rotate:deg=180", image.Pt(width, height), func(x, y int) (int, int) { return width - 1 - x, height - 1 - y }},
+		{"rotate:deg=270", image.Pt(height, width), func(x, y int) (int, int) { return width - 1 - y, x }},
+		{"rotate:deg=720", image.Pt(width, height), func(x, y int) (int, int) { return x, y }},
+		{"flip:dir=h", image.Pt(width, height), func(x, y int) (int, int) { return width - 1 - x, y }},
+		{"flip:dir=v", image.Pt(width, height), func(x, y int) (int, int) { return x, height - 1 - y }},
+		{"transpose", image.Pt(height, width), func(x, y int) (int, int) { return y, x }},
+		{"crop:x=1,y=1,w=3", image.Pt(3, 2), func(x, y int) (int, int) { return x + 1, y + 1 }},
+	}
+	for _, test := range tests {
+		for _, filter := range []string{"", ",filter=bicubic"} {
+			effect := test.effect + filter
+			if filter != "" && test.effect[:6] != "rotate" {
+				continue
+			}
+			out := runOn(src, false, effect)
+			if got := out.Bounds().Size(); got != test.size {
+				t.Errorf("%s: size %v, want %v", effect, got, test.size)
+				continue
+			}
+			for y := 0; y < test.size.Y; y++ {
+				for x := 0; x < test.size.X; x++ {
+					sx, sy := test.from(x, y)
+					want := color.RGBA64Model.Convert(src.At(sx, sy)).(color.RGBA64)
+					if got := out.RGBA64At(x, y); got != want {
+						t.Errorf("%s: pixel (%d, %d) = %v, want %v from (%d, %d)", effect, x, y, got, want, sx, sy)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRotateBounds(t *testing.T) {
+	out := runOn(solid(20, 10, color.White), false, "rotate:deg=45")
+	// The bounding box of a 20x10 rectangle turned by 45 degrees.
+	if got := out.Bounds().Size(); got != image.Pt(22, 22) {
+		t.Errorf("size %v, want 22x22", got)
+	}
+	if c := out.RGBA64At(0, 0); c.A != 0 {
+		t.Errorf("uncovered corner %v, want transparent", c)
+	}
+	if c := out.RGBA64At(11, 11); c != (color.RGBA64{0xffff, 0xffff, 0xffff, 0xffff}) {
+		t.Errorf("centre %v, want white", c)
+	}
+}
+
+func TestRotateNonFinite(t *testing.T) {
+	for _, deg := range []string{"nan", "inf", "-inf"} {
+		img := newImage(solid(4, 4, color.White))
+		if err := img.applyEffect("rotate:deg=" + deg); err == nil {
+			t.Errorf("rotate:deg=%s: expected an error", deg)
+		}
+	}
+}
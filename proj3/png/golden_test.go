@@ -0,0 +1,71 @@
+package png
+
+import (
+	"flag"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"proj3/metrics"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden images in testdata/golden")
+
+// goldenTolerance is the largest per-channel difference, in 16-bit units,
+// accepted between an output and its golden image. It absorbs floating
+// point differences between platforms.
+const goldenTolerance = 2
+
+// checkGolden compares got with testdata/golden/name.png, or rewrites the
+// golden image when the -update flag is set.
+func checkGolden(t *testing.T, name string, got *image.RGBA64) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name+".png")
+	if *update {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := png.Encode(f, got); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Bounds().Size() != want.Bounds().Size() {
+		t.Fatalf("%s: size %v, golden image is %v", name, got.Bounds().Size(), want.Bounds().Size())
+	}
+	report, err := metrics.Compare(got, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.MaxAbsDiff > goldenTolerance {
+		t.Errorf("%s differs from its golden image: %v", name, report)
+	}
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// runOn applies effects to a copy of src and returns the result.
+func runOn(src image.Image, linear bool, effects ...string) *image.RGBA64 {
+	img := newImage(src)
+	img.LinearLight = linear
+	img.RunEffects(effects)
+	return img.out
+}
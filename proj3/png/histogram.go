@@ -0,0 +1,249 @@
+package png
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// BT.709 luma coefficients and the matching YCbCr chroma scale factors.
+const (
+	lumaR = 0.2126
+	lumaG = 0.7152
+	lumaB = 0.0722
+	cbDiv = 2 * (1 - lumaB)
+	crDiv = 2 * (1 - lumaR)
+)
+
+// toYCbCr returns the luma and chroma of a premultiplied pixel after undoing
+// the premultiplication. The components stay in [0, 65535] scale.
+func toYCbCr(c color.RGBA64) (y, cb, cr float64) {
+	if c.A == 0 {
+		return 0, 0, 0
+	}
+	k := 65535 / float64(c.A)
+	r, g, b := float64(c.R)*k, float64(c.G)*k, float64(c.B)*k
+	y = lumaR*r + lumaG*g + lumaB*b
+	return y, (b - y) / cbDiv, (r - y) / crDiv
+}
+
+// fromYCbCr is the inverse of toYCbCr, premultiplying by alpha a.
+func fromYCbCr(y, cb, cr float64, a uint16) color.RGBA64 {
+	r := y + crDiv*cr
+	b := y + cbDiv*cb
+	g := (y - lumaR*r - lumaB*b) / lumaG
+	// Bright luma with strong chroma can leave the gamut, so bound the
+	// premultiplied colour by alpha.
+	k := float64(a) / 65535
+	return color.RGBA64{clampTo(math.Round(r*k), a), clampTo(math.Round(g*k), a), clampTo(math.Round(b*k), a), a}
+}
+
+// mapLuma replaces the luma of every pixel with lut(x, y, luma), keeping its
+// chroma and alpha, so tone changes don't shift colours.
+func (img *Image) mapLuma(lut func(x, y int, luma float64) float64) {
+	bounds := img.out.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.in.RGBA64At(x, y)
+			if c.A == 0 {
+				img.out.SetRGBA64(x, y, c)
+				continue
+			}
+			luma, cb, cr := toYCbCr(c)
+			img.out.SetRGBA64(x, y, fromYCbCr(lut(x, y, luma), cb, cr, c.A))
+		}
+	}
+}
+
+// Equalize spreads the luminance histogram of the image evenly over the full
+// range. Fully transparent pixels are ignored.
+func (img *Image) Equalize() {
+	const bins = 1 << 16
+	hist := make([]int, bins)
+	bounds := img.in.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.in.RGBA64At(x, y)
+			if c.A == 0 {
+				continue
+			}
+			luma, _, _ := toYCbCr(c)
+			hist[clamp(math.Round(luma))]++
+		}
+	}
+	lut := equalizationLUT(hist)
+	img.mapLuma(func(_, _ int, luma float64) float64 {
+		return lut[clamp(math.Round(luma))]
+	})
+}
+
+// equalizationLUT turns a histogram into the mapping from bin to output level
+// given by its normalised cumulative distribution. Output levels span
+// [0, 65535] whatever the number of bins.
+func equalizationLUT(hist []int) []float64 {
+	total, cdfMin := 0, -1
+	for _, n := range hist {
+		total += n
+		if cdfMin < 0 && n > 0 {
+			cdfMin = n
+		}
+	}
+	lut := make([]float64, len(hist))
+	if total == cdfMin || cdfMin < 0 {
+		// Flat image: nothing to spread, keep the identity mapping.
+		for i := range lut {
+			lut[i] = float64(i) * 65535 / float64(len(hist)-1)
+		}
+		return lut
+	}
+	cdf := 0
+	for i, n := range hist {
+		cdf += n
+		lut[i] = math.Max(0, float64(cdf-cdfMin)) / float64(total-cdfMin) * 65535
+	}
+	return lut
+}
+
+// claheBins is the number of histogram bins used per CLAHE tile. Tiles hold
+// far fewer pixels than 16-bit levels, so luma is binned and the resulting
+// mapping interpolated between bins.
+const claheBins = 256
+
+// CLAHE applies contrast-limited adaptive histogram equalization to the
+// luminance of the image. The image is split into tileSize x tileSize tiles,
+// each tile's histogram is clipped at clipLimit times its mean bin count
+// (the excess is spread evenly over all bins) and equalized, and every pixel
+// is mapped by bilinearly interpolating the mappings of the four nearest
+// tiles. A clipLimit of 1 leaves the image almost unchanged; larger values
+// allow more contrast.
+func (img *Image) CLAHE(tileSize int, clipLimit float64) {
+	bounds := img.in.Bounds()
+	tilesX := (bounds.Dx() + tileSize - 1) / tileSize
+	tilesY := (bounds.Dy() + tileSize - 1) / tileSize
+
+	// Build one mapping per tile.
+	luts := make([][]float64, tilesX*tilesY)
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			hist := make([]int, claheBins)
+			count := 0
+			for y := bounds.Min.Y + ty*tileSize; y < bounds.Min.Y+(ty+1)*tileSize && y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X + tx*tileSize; x < bounds.Min.X+(tx+1)*tileSize && x < bounds.Max.X; x++ {
+					c := img.in.RGBA64At(x, y)
+					if c.A == 0 {
+						continue
+					}
+					luma, _, _ := toYCbCr(c)
+					hist[lumaBin(luma)]++
+					count++
+				}
+			}
+			clipHistogram(hist, count, clipLimit)
+			luts[ty*tilesX+tx] = claheLUT(hist)
+		}
+	}
+
+	// Interpolate between the mappings of the surrounding tile centres.
+	half := float64(tileSize) / 2
+	img.mapLuma(func(x, y int, luma float64) float64 {
+		fx := (float64(x-bounds.Min.X) + 0.5 - half) / float64(tileSize)
+		fy := (float64(y-bounds.Min.Y) + 0.5 - half) / float64(tileSize)
+		x0, y0 := int(math.Floor(fx)), int(math.Floor(fy))
+		wx, wy := fx-float64(x0), fy-float64(y0)
+		x1, y1 := clampIndex(x0+1, 0, tilesX), clampIndex(y0+1, 0, tilesY)
+		x0, y0 = clampIndex(x0, 0, tilesX), clampIndex(y0, 0, tilesY)
+
+		at := func(tx, ty int) float64 { return lookupBins(luts[ty*tilesX+tx], luma) }
+		top := at(x0, y0)*(1-wx) + at(x1, y0)*wx
+		bottom := at(x0, y1)*(1-wx) + at(x1, y1)*wx
+		return top*(1-wy) + bottom*wy
+	})
+}
+
+// lumaBin returns the CLAHE histogram bin holding luma.
+func lumaBin(luma float64) int {
+	return clampIndex(int(luma*claheBins/65536), 0, claheBins)
+}
+
+// clipHistogram caps every bin at clipLimit times the mean bin count and
+// redistributes the clipped pixels evenly over all bins.
+func clipHistogram(hist []int, count int, clipLimit float64) {
+	limit := int(math.Max(1, clipLimit*float64(count)/float64(len(hist))))
+	excess := 0
+	for i, n := range hist {
+		if n > limit {
+			excess += n - limit
+			hist[i] = limit
+		}
+	}
+	share, rest := excess/len(hist), excess%len(hist)
+	for i := range hist {
+		hist[i] += share
+		if i < rest {
+			hist[i]++
+		}
+	}
+}
+
+// claheLUT maps each bin to the output level at the top of its cumulative
+// distribution. Unlike equalizationLUT the darkest level is not pulled down
+// to zero, which keeps neighbouring tiles consistent.
+func claheLUT(hist []int) []float64 {
+	total := 0
+	for _, n := range hist {
+		total += n
+	}
+	lut := make([]float64, len(hist))
+	if total == 0 {
+		for i := range lut {
+			lut[i] = (float64(i) + 1) * 65535 / float64(len(lut))
+		}
+		return lut
+	}
+	cdf := 0
+	for i, n := range hist {
+		cdf += n
+		lut[i] = float64(cdf) / float64(total) * 65535
+	}
+	return lut
+}
+
+// lookupBins evaluates a per-bin mapping at luma, interpolating linearly
+// between the top of the previous bin and the top of luma's bin.
+func lookupBins(lut []float64, luma float64) float64 {
+	pos := luma * float64(len(lut)) / 65536
+	bin := clampIndex(int(pos), 0, len(lut))
+	lo := 0.0
+	if bin > 0 {
+		lo = lut[bin-1]
+	}
+	frac := pos - float64(bin)
+	return lo + (lut[bin]-lo)*frac
+}
+
+// parseCLAHE reads a "clahe" entry. Accepted arguments:
+//
+//	tile  tile size in pixels (default 64)
+//	clip  clip limit as a multiple of the mean bin count (default 2)
+func parseCLAHE(spec effectSpec) (tile int, clip float64, err error) {
+	if tile, err = spec.int("tile", 64); err != nil {
+		return 0, 0, err
+	}
+	if clip, err = spec.float("clip", 2); err != nil {
+		return 0, 0, err
+	}
+	if tile <= 0 || clip <= 0 {
+		return 0, 0, fmt.Errorf("clahe: tile and clip must be positive")
+	}
+	return tile, clip, nil
+}
+
+// runCLAHE applies a "clahe" entry from effects.txt (see parseCLAHE).
+func (img *Image) runCLAHE(spec effectSpec) error {
+	tile, clip, err := parseCLAHE(spec)
+	if err != nil {
+		return err
+	}
+	img.CLAHE(tile, clip)
+	return nil
+}
@@ -0,0 +1,79 @@
+package png
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestEqualizationLUT(t *testing.T) {
+	// Four used bins holding 1, 2, 3 and 4 pixels: the first maps to 0 and
+	// the others to their share of the remaining cumulative distribution.
+	hist := make([]int, 8)
+	hist[1], hist[3], hist[4], hist[6] = 1, 2, 3, 4
+	lut := equalizationLUT(hist)
+	want := []float64{0, 0, 0, 2.0 / 9, 5.0 / 9, 5.0 / 9, 1, 1}
+	for i := range want {
+		if math.Abs(lut[i]-want[i]*65535) > 1e-6 {
+			t.Errorf("lut[%d] = %g, want %g", i, lut[i], want[i]*65535)
+		}
+	}
+
+	// A flat image keeps the identity mapping.
+	flat := make([]int, 5)
+	flat[2] = 10
+	if lut := equalizationLUT(flat); lut[0] != 0 || lut[2] != 65535.0/2 || lut[4] != 65535 {
+		t.Errorf("flat image mapping %v, want the identity", lut)
+	}
+}
+
+func TestClipHistogram(t *testing.T) {
+	hist := []int{0, 40, 0, 0, 4, 0, 0, 4}
+	count := 48
+	clipHistogram(hist, count, 2)
+	// The limit is twice the mean of 6 per bin; the 28 pixels above it
+	// are spread over the 8 bins, 3 each and 1 more for the first 4.
+	want := []int{4, 16, 4, 4, 7, 3, 3, 7}
+	total := 0
+	for i, n := range hist {
+		if n != want[i] {
+			t.Errorf("bin %d holds %d, want %d", i, n, want[i])
+		}
+		total += n
+	}
+	if total != count {
+		t.Errorf("clipping changed the pixel count from %d to %d", count, total)
+	}
+
+	// The clip limit bounds how steep the mapping can get.
+	lut := claheLUT(hist)
+	for i := 1; i < len(lut); i++ {
+		if step := lut[i] - lut[i-1]; step < 0 || step > 16.0/float64(count)*65535+1e-6 {
+			t.Errorf("mapping step %g at bin %d exceeds the clip limit", step, i)
+		}
+	}
+}
+
+func TestYCbCrRoundTrip(t *testing.T) {
+	for _, c := range []color.RGBA64{
+		{0xffff, 0, 0, 0xffff},
+		{0x1234, 0x5678, 0x9abc, 0xffff},
+		{0x4000, 0x2000, 0x1000, 0x8000},
+	} {
+		y, cb, cr := toYCbCr(c)
+		if got := fromYCbCr(y, cb, cr, c.A); got != c {
+			t.Errorf("round trip of %v gave %v", c, got)
+		}
+	}
+}
+
+func TestFromYCbCrBoundedByAlpha(t *testing.T) {
+	// Bright luma with strong chroma lies outside the gamut; on a
+	// translucent pixel the colour must still not exceed alpha.
+	for _, a := range []uint16{0x8000, 0x100, 0xffff} {
+		c := fromYCbCr(60000, -20000, 30000, a)
+		if c.R > a || c.G > a || c.B > a {
+			t.Errorf("alpha %#x: %v is not validly premultiplied", a, c)
+		}
+	}
+}
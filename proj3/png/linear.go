@@ -0,0 +1,81 @@
+package png
+
+import (
+	"image"
+	"sync"
+)
+
+// Convolution and resampling average neighbouring pixels, which is only
+// physically meaningful on linear light. Averaging gamma-encoded sRGB values
+// darkens the result, so a blurred bright edge gets a dark halo. When
+// Image.LinearLight is set, RunEffects converts the image to linear light
+// before such effects and back to sRGB before effects that expect sRGB (tone
+// and colour adjustments) and at the end of the chain, so Save always
+// writes sRGB.
+
+// linearEffects lists the effects that run on linear light in LinearLight
+// mode.
+var linearEffects = map[string]bool{
+	"S": true, "E": true, "B": true,
+	"resize": true, "rotate": true, "affine": true, "perspective": true,
+}
+
+// movingEffects only move pixels around, so they run on whichever encoding
+// the previous effect left.
+var movingEffects = map[string]bool{
+	"flip": true, "transpose": true, "crop": true,
+}
+
+// wantsLinear reports whether the effects.txt entry effect should run on
+// linear light in LinearLight mode, given whether the image currently is.
+func wantsLinear(effect string, current bool) bool {
+	spec, err := parseEffect(effect)
+	if err != nil || movingEffects[spec.name] {
+		return current
+	}
+	return linearEffects[spec.name]
+}
+
+var (
+	linearOnce sync.Once
+	toLinear   LUT
+	toSRGB     LUT
+)
+
+// linearLUT returns the 16-bit LUT decoding sRGB to linear light.
+func linearLUT() LUT {
+	linearOnce.Do(buildLinearLUTs)
+	return toLinear
+}
+
+// sRGBLUT returns the 16-bit LUT encoding linear light to sRGB.
+func sRGBLUT() LUT {
+	linearOnce.Do(buildLinearLUTs)
+	return toSRGB
+}
+
+func buildLinearLUTs() {
+	toLinear = NewLUT(SRGBToLinear)
+	toSRGB = NewLUT(LinearToSRGB)
+}
+
+// convertInput converts the input buffer in place to linear light (linear is
+// true) or back to sRGB.
+func (img *Image) convertInput(linear bool) {
+	if linear {
+		convertBuffer(img.in, linearLUT())
+	} else {
+		convertBuffer(img.in, sRGBLUT())
+	}
+}
+
+// convertBuffer maps the straight colour of every pixel of buf through lut in
+// place, keeping it premultiplied.
+func convertBuffer(buf *image.RGBA64, lut LUT) {
+	bounds := buf.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			buf.SetRGBA64(x, y, lut.lookup(buf.RGBA64At(x, y)))
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// edgeImage returns a 16x8 image that is black on the left half and white
+// (or the given colour) on the right half.
+func edgeImage(right color.Color) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			if x < 8 {
+				img.Set(x, y, color.Black)
+			} else {
+				img.Set(x, y, right)
+			}
+		}
+	}
+	return img
+}
+
+func TestLinearLightGolden(t *testing.T) {
+	red := color.NRGBA{255, 0, 0, 255}
+	tests := []struct {
+		name    string
+		src     image.Image
+		effects []string
+	}{
+		{"blur", edgeImage(color.White), []string{"B"}},
+		{"blur_red", edgeImage(red), []string{"B", "B"}},
+		{"sharpen", edgeImage(color.Gray{128}), []string{"S"}},
+		{"resize", edgeImage(color.White), []string{"resize:w=5,filter=bilinear"}},
+		{"blur_gamma", edgeImage(color.White), []string{"B", "gamma:v=2", "B"}},
+	}
+	for _, test := range tests {
+		for _, linear := range []bool{false, true} {
+			name := "linear_" + test.name + "_srgb"
+			if linear {
+				name = "linear_" + test.name + "_linear"
+			}
+			checkGolden(t, name, runOn(test.src, linear, test.effects...))
+		}
+	}
+}
+
+// A blurred black/white edge in sRGB mode averages encoded values, which
+// makes the transition darker than the average light. Linear mode must
+// produce brighter transition pixels and leave flat areas alone.
+func TestLinearLightNoDarkHalo(t *testing.T) {
+	src := edgeImage(color.White)
+	gamma := runOn(src, false, "B")
+	linear := runOn(src, true, "B")
+
+	for _, x := range []int{7, 8} {
+		g, l := gamma.RGBA64At(x, 4).R, linear.RGBA64At(x, 4).R
+		if l <= g {
+			t.Errorf("x=%d: linear blur %d is not brighter than sRGB blur %d", x, l, g)
+		}
+	}
+	for _, x := range []int{3, 12} {
+		if g, l := gamma.RGBA64At(x, 4), linear.RGBA64At(x, 4); absDiff(uint32(g.R), uint32(l.R)) > goldenTolerance {
+			t.Errorf("x=%d: flat area changed between modes: %v vs %v", x, g, l)
+		}
+	}
+}
+
+func TestLinearLUTRoundTrip(t *testing.T) {
+	// The steep start of the sRGB curve loses a few levels to rounding in
+	// the 16-bit linear table; anything more is a broken table.
+	const tolerance = 8
+	toLin, toSRGB := linearLUT(), sRGBLUT()
+	for v := 0; v < 1<<16; v += 257 {
+		if back := toSRGB[toLin[v]]; absDiff(uint32(back), uint32(v)) > tolerance {
+			t.Errorf("sRGB %d -> linear %d -> sRGB %d", v, toLin[v], back)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package png
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// A Mask gives how much of an effect shows at pixel (x, y), from 0 (the
+// pixel keeps its value from before the effect) to 1 (it takes the effect
+// in full).
+type Mask func(x, y int) float64
+
+// RectMask restricts an effect to r. With a positive feather the effect fades
+// in linearly over the feather pixels inside each edge of r instead of
+// starting abruptly.
+func RectMask(r image.Rectangle, feather float64) Mask {
+	return func(x, y int) float64 {
+		if !(image.Point{x, y}).In(r) {
+			return 0
+		}
+		if feather <= 0 {
+			return 1
+		}
+		// Distance from the centre of the pixel to the nearest edge.
+		d := math.Min(
+			math.Min(float64(x-r.Min.X)+0.5, float64(r.Max.X-x)-0.5),
+			math.Min(float64(y-r.Min.Y)+0.5, float64(r.Max.Y-y)-0.5),
+		)
+		return math.Min(1, d/feather)
+	}
+}
+
+// ImageMask uses the gray level of m as the amount of the effect, so white
+// applies it fully, black not at all and grays blend in between. Colour
+// masks use the mean of their channels; transparent areas count as black.
+// m is aligned with the top left corner of the image and pixels it doesn't
+// cover are left unchanged.
+func ImageMask(m *image.RGBA64) Mask {
+	bounds := m.Bounds()
+	return func(x, y int) float64 {
+		p := image.Pt(x, y).Add(bounds.Min)
+		if !p.In(bounds) {
+			return 0
+		}
+		r, g, b, _ := getPix(m.Pix, m.PixOffset(p.X, p.Y))
+		return (float64(r) + float64(g) + float64(b)) / (3 * 65535)
+	}
+}
+
+// Restrict limits the effect that just ran (from the input to the output
+// buffer) to mask, blending each output pixel back towards its input by the
+// mask value. The effect must not have changed the size of the image.
+func (img *Image) Restrict(mask Mask) {
+	bounds := img.out.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		i := img.in.PixOffset(bounds.Min.X, y)
+		o := img.out.PixOffset(bounds.Min.X, y)
+		for x := bounds.Min.X; x < bounds.Max.X; x, i, o = x+1, i+8, o+8 {
+			w := mask(x, y)
+			if w >= 1 {
+				continue
+			}
+			r0, g0, b0, a0 := getPix(img.in.Pix, i)
+			if w <= 0 {
+				setPix(img.out.Pix, o, r0, g0, b0, a0)
+				continue
+			}
+			// Premultiplied pixels interpolate linearly, colour and
+			// coverage together.
+			r1, g1, b1, a1 := getPix(img.out.Pix, o)
+			mix := func(v0, v1 uint16) uint16 {
+				return clamp(math.Round(float64(v0) + w*(float64(v1)-float64(v0))))
+			}
+			setPix(img.out.Pix, o, mix(r0, r1), mix(g0, g1), mix(b0, b1), mix(a0, a1))
+		}
+	}
+}
+
+// regionMask builds the mask an effects.txt entry restricts itself to with
+// its common arguments, or returns nil when it applies to the whole image:
+//
+//	roi=x y w h   only the w x h rectangle at (x, y)
+//	feather=8     fade the rectangle in over 8 pixels inside its edges
+//	mask=path     weight by the gray level of the image at path, relative
+//	              to img.Dir
+//
+// The arguments are removed from spec so the effect itself doesn't see
+// them.
+func (img *Image) regionMask(spec effectSpec) (Mask, error) {
+	defer func() {
+		delete(spec.args, "roi")
+		delete(spec.args, "feather")
+		delete(spec.args, "mask")
+	}()
+	var masks []Mask
+	if spec.has("roi") {
+		roi, err := spec.floats("roi")
+		if err != nil {
+			return nil, err
+		}
+		if len(roi) != 4 || roi[2] <= 0 || roi[3] <= 0 {
+			return nil, fmt.Errorf("effect %q: roi needs x y w h with a positive size", spec.name)
+		}
+		feather, err := spec.float("feather", 0)
+		if err != nil {
+			return nil, err
+		}
+		x, y := int(roi[0]), int(roi[1])
+		masks = append(masks, RectMask(image.Rect(x, y, x+int(roi[2]), y+int(roi[3])), feather))
+	} else if spec.has("feather") {
+		return nil, fmt.Errorf("effect %q: feather needs a roi", spec.name)
+	}
+	if path := spec.str("mask", ""); path != "" {
+		m, err := LoadOverlay(img.resolve(path))
+		if err != nil {
+			return nil, fmt.Errorf("effect %q: mask: %v", spec.name, err)
+		}
+		masks = append(masks, ImageMask(m))
+	}
+
+	switch len(masks) {
+	case 0:
+		return nil, nil
+	case 1:
+		return masks[0], nil
+	}
+	// Both: the mask within the rectangle.
+	return func(x, y int) float64 {
+		return masks[0](x, y) * masks[1](x, y)
+	}, nil
+}
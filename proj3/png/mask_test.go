@@ -0,0 +1,123 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+// checker returns a 20x20 black and white checkerboard of single pixels,
+// which blurring changes everywhere.
+func checker() image.Image {
+	m := image.NewGray(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			m.Pix[y*m.Stride+x] = uint8((x + y) % 2 * 0xff)
+		}
+	}
+	return m
+}
+
+func TestRegionEffects(t *testing.T) {
+	src := checker()
+	full := runOn(src, false, "B")
+	orig := newImage(src).in
+	roi := image.Rect(4, 6, 14, 12)
+
+	got := runOn(src, false, "B:roi=4 6 10 6")
+	for y := got.Rect.Min.Y; y < got.Rect.Max.Y; y++ {
+		for x := got.Rect.Min.X; x < got.Rect.Max.X; x++ {
+			want := orig.RGBA64At(x, y)
+			if image.Pt(x, y).In(roi) {
+				want = full.RGBA64At(x, y)
+			}
+			if c := got.RGBA64At(x, y); c != want {
+				t.Fatalf("pixel (%d, %d) is %v, want %v", x, y, c, want)
+			}
+		}
+	}
+
+	// Feathering fades in from the edge: the outermost ring is only partly
+	// blurred, the centre fully.
+	feathered := runOn(src, false, "B:roi=2 2 16 16,feather=3")
+	if c := feathered.RGBA64At(10, 10); c != full.RGBA64At(10, 10) {
+		t.Errorf("centre of feathered roi is %v, want %v", c, full.RGBA64At(10, 10))
+	}
+	edge, before, after := feathered.RGBA64At(2, 10), orig.RGBA64At(2, 10), full.RGBA64At(2, 10)
+	if lo, hi := minMax(before.G, after.G); edge.G <= lo || edge.G >= hi {
+		t.Errorf("feathered edge green %d is not strictly between %d and %d", edge.G, before.G, after.G)
+	}
+}
+
+func minMax(a, b uint16) (uint16, uint16) {
+	if a < b {
+		return a, b
+	}
+	return b, a
+}
+
+func TestImageMask(t *testing.T) {
+	src := gradient()
+	bounds := src.Bounds()
+	// White on the left half, 50% gray on the right.
+	m := image.NewGray(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			v := uint8(0xff)
+			if x >= bounds.Dx()/2 {
+				v = 0x80
+			}
+			m.SetGray(x, y, color.Gray{v})
+		}
+	}
+	path := filepath.Join(t.TempDir(), "mask.png")
+	writePNG(t, path, m)
+
+	orig := newImage(src).in
+	full := runOn(src, false, "G")
+	got := runOn(src, false, "grayscale:mask="+path)
+	weight := float64(0x8080) / 0xffff
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c, want := got.RGBA64At(x, y), full.RGBA64At(x, y)
+			if x >= bounds.Dx()/2 {
+				o := orig.RGBA64At(x, y)
+				want.R = uint16(float64(o.R) + weight*(float64(want.R)-float64(o.R)) + 0.5)
+				want.G = uint16(float64(o.G) + weight*(float64(want.G)-float64(o.G)) + 0.5)
+				want.B = uint16(float64(o.B) + weight*(float64(want.B)-float64(o.B)) + 0.5)
+			}
+			if c != want {
+				t.Fatalf("pixel (%d, %d) is %v, want %v", x, y, c, want)
+			}
+		}
+	}
+
+	// A relative mask path is resolved against Dir, in strips too.
+	img := newImage(src)
+	img.Dir = filepath.Dir(path)
+	img.RunEffects([]string{"grayscale:mask=mask.png"})
+	if !equalPix(img.out, got) {
+		t.Error("mask relative to Dir differs from the absolute path")
+	}
+	in := filepath.Join(img.Dir, "in.png")
+	writePNG(t, in, src)
+	if err := RunTiled(in, filepath.Join(img.Dir, "out.png"), []string{"grayscale:mask=mask.png"}, 4, false, img.Dir, SaveOptions{}); err != nil {
+		t.Errorf("strips with a mask relative to dir: %v", err)
+	}
+}
+
+func TestRegionErrors(t *testing.T) {
+	for _, effect := range []string{
+		"resize:w=5,roi=0 0 4 4",
+		"B:roi=0 0 4",
+		"B:feather=2",
+		"B:radius=2",
+		"B:mask=does-not-exist.png",
+	} {
+		img := newImage(gradient())
+		if err := img.applyEffect(effect); err == nil {
+			t.Errorf("%q: no error", effect)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// codecBytes bounds the working memory of a decoder or encoder besides its
+// rows and images: zlib windows, Huffman tables and read buffers.
+const codecBytes = 1 << 20
+
+// MemoryEstimate returns about how many bytes processing the image at path
+// takes, read from its header without decoding it: with stripRows > 0 what
+// RunTiled needs, otherwise what Load and RunEffects need. Relative overlay
+// and mask paths are resolved against dir, as Image.Dir does.
+//
+// The estimate adds up every buffer the task takes from DefaultPool, rounded
+// up to the pool's bucket sizes, the working memory of each effect, the
+// overlays and masks it loads and what decoding takes. Images Load can't
+// decode a row at a time (interlaced PNGs and other formats) also hold the
+// decoded copy. Check Tileable before asking for a strip estimate; effects
+// that can't run in strips are estimated as loaded whole. An entry with bad
+// arguments fails when it runs, so nothing from it on is counted.
+func MemoryEstimate(path string, effects []string, stripRows int, dir string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	width, height, decoded, err := sourceSize(file)
+	if err != nil {
+		return 0, err
+	}
+	bounds := image.Rect(0, 0, width, height)
+	n := int64(codecBytes) + 2*rowBytes(width)
+
+	if stripRows > 0 {
+		if halo, err := stripHalo(effects); err == nil {
+			// The window of decoded rows, the strip's two buffers and the
+			// encoder writing the rows back out.
+			rows := stripRows + 2*halo
+			if rows > height {
+				rows = height
+			}
+			bounds = image.Rect(0, 0, width, rows)
+			n += 8*pixels(bounds) + 2*bufferBytes(bounds) + codecBytes + 6*rowBytes(width)
+			for _, effect := range effects {
+				s, err := stepCost(effect, bounds, dir)
+				if err != nil {
+					break
+				}
+				n += s.scratch
+			}
+			return n, nil
+		}
+	}
+
+	n += decoded*pixels(bounds) + 2*bufferBytes(bounds)
+	for _, effect := range effects {
+		s, err := stepCost(effect, bounds, dir)
+		if err != nil {
+			break
+		}
+		n += s.scratch + int64(s.buffers)*bufferBytes(s.bounds)
+		bounds = s.bounds
+	}
+	return n, nil
+}
+
+// step is what running one effects.txt entry takes on top of the two
+// buffers it runs between.
+type step struct {
+	bounds  image.Rectangle // the size of its output
+	buffers int             // buffers of that size it takes from the pool
+	scratch int64           // bytes of working memory
+}
+
+// stepCost returns what the effects.txt entry effect takes on an input
+// covering bounds, parsing it as running it does. Effects that remap pixels
+// take a new output buffer, and one that changes the size of the image takes
+// another when the next effect swaps its output in.
+func stepCost(effect string, bounds image.Rectangle, dir string) (step, error) {
+	s := step{bounds: bounds}
+	spec, err := parseEffect(effect)
+	if err != nil {
+		return s, err
+	}
+	if path := spec.str("mask", ""); path != "" {
+		if s.scratch, err = overlayBytes(resolvePath(dir, path)); err != nil {
+			return s, err
+		}
+	}
+
+	var out image.Rectangle
+	switch spec.name {
+	case "S", "E", "B":
+		// The clamped row and column offsets.
+		s.scratch += 8 * int64(bounds.Dx()+bounds.Dy()+4)
+	case "resize":
+		r, err := parseResize(spec, bounds)
+		if err != nil {
+			return s, err
+		}
+		out = image.Rect(0, 0, r.width, r.height)
+		s.scratch += r.scratch(bounds)
+	case "rotate":
+		r, err := parseRotate(spec)
+		if err != nil {
+			return s, err
+		}
+		width, height := rotationSize(bounds.Dx(), bounds.Dy(), r.degrees)
+		out = image.Rect(0, 0, width, height)
+	case "flip":
+		out = image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	case "transpose":
+		out = image.Rect(0, 0, bounds.Dy(), bounds.Dx())
+	case "crop":
+		rect, err := parseCrop(spec, bounds)
+		if err != nil {
+			return s, err
+		}
+		if rect, err = clipCrop(rect, bounds); err != nil {
+			return s, err
+		}
+		out = image.Rect(0, 0, rect.Dx(), rect.Dy())
+	case "affine", "perspective":
+		w, err := parseWarp(spec, bounds)
+		if err != nil {
+			return s, err
+		}
+		out = image.Rect(0, 0, w.width, w.height)
+	case "equalize":
+		// A histogram and a mapping over every 16-bit level.
+		s.scratch += 2 * 8 << 16
+	case "clahe":
+		tile, _, err := parseCLAHE(spec)
+		if err != nil {
+			return s, err
+		}
+		tiles := int64((bounds.Dx()+tile-1)/tile) * int64((bounds.Dy()+tile-1)/tile)
+		s.scratch += tiles * (2*8*claheBins + 24)
+	case "brightness", "contrast", "gamma", "levels", "curves":
+		s.scratch += 2 << 16
+	case "overlay":
+		n, err := overlayBytes(resolvePath(dir, spec.str("src", "")))
+		if err != nil {
+			return s, err
+		}
+		s.scratch += n
+	}
+	if out != (image.Rectangle{}) {
+		s.bounds, s.buffers = out, 1
+		if out.Size() != bounds.Size() {
+			s.buffers = 2
+		}
+	}
+	return s, nil
+}
+
+// overlayBytes returns what LoadOverlay takes to load the image at path:
+// its own 16-bit copy, which stays cached, and what decoding it takes.
+func overlayBytes(path string) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	width, height, decoded, err := headerSize(file)
+	if err != nil {
+		return 0, err
+	}
+	n := int64(width) * int64(height)
+	return (8+2*decoded)*n + codecBytes + 2*rowBytes(width), nil
+}
+
+// sourceSize reads the size of the image in r from its header, with the
+// bytes per pixel Load decodes before converting it: none for the PNGs it
+// decodes a row at a time into its own buffers. Decoders take about as much
+// again for interlaced passes, JPEG coefficients or compressed TIFF strips,
+// which the second copy counts.
+func sourceSize(r io.ReadSeeker) (width, height int, decoded int64, err error) {
+	if d, err := newRowDecoder(r); err == nil {
+		return d.width, d.height, 0, nil
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, 0, err
+	}
+	width, height, decoded, err = headerSize(r)
+	return width, height, 2 * decoded, err
+}
+
+// headerSize reads the size of the image in r from its header with
+// image.DecodeConfig, and the bytes per pixel image.Decode returns it in.
+func headerSize(r io.Reader) (width, height int, decoded int64, err error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	decoded = 4
+	switch config.ColorModel {
+	case color.RGBA64Model, color.NRGBA64Model:
+		decoded = 8
+	case color.Gray16Model:
+		decoded = 2
+	case color.GrayModel, color.AlphaModel:
+		decoded = 1
+	default:
+		if _, ok := config.ColorModel.(color.Palette); ok {
+			decoded = 1
+		}
+	}
+	return config.Width, config.Height, decoded, nil
+}
+
+// pixels returns the number of pixels bounds covers.
+func pixels(bounds image.Rectangle) int64 {
+	return int64(bounds.Dx()) * int64(bounds.Dy())
+}
+
+// bufferBytes returns the capacity of the buffer DefaultPool.Get hands out
+// for bounds: a power of two, unless there is no pool.
+func bufferBytes(bounds image.Rectangle) int64 {
+	n := 8 * pixels(bounds)
+	if DefaultPool == nil || n <= 1 {
+		return n
+	}
+	return 1 << bucketOf(int(n))
+}
+
+// rowBytes returns the size of a 16-bit RGBA row of width pixels as PNG
+// codecs keep it, filter byte included.
+func rowBytes(width int) int64 {
+	return 1 + 8*int64(width)
+}
+
+// resolvePath returns path relative to dir, unless it is absolute.
+func resolvePath(dir, path string) string {
+	if dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
@@ -0,0 +1,124 @@
+package png
+
+import (
+	"image"
+	"image/color"
+)
+
+// Hot loops read and write image.RGBA64.Pix directly instead of going
+// through At and Set, which box every pixel in a color.Color interface.
+// Each RGBA64 pixel is 8 bytes: R, G, B and A as big-endian uint16s.
+
+// getPix returns the pixel stored at offset i of pix.
+func getPix(pix []uint8, i int) (r, g, b, a uint16) {
+	s := pix[i : i+8 : i+8]
+	return uint16(s[0])<<8 | uint16(s[1]),
+		uint16(s[2])<<8 | uint16(s[3]),
+		uint16(s[4])<<8 | uint16(s[5]),
+		uint16(s[6])<<8 | uint16(s[7])
+}
+
+// setPix stores a pixel at offset i of pix.
+func setPix(pix []uint8, i int, r, g, b, a uint16) {
+	s := pix[i : i+8 : i+8]
+	s[0], s[1] = uint8(r>>8), uint8(r)
+	s[2], s[3] = uint8(g>>8), uint8(g)
+	s[4], s[5] = uint8(b>>8), uint8(b)
+	s[6], s[7] = uint8(a>>8), uint8(a)
+}
+
+// copyInto converts src into dst, which must have the same bounds, with
+// fast paths for the image types the decoders return.
+func copyInto(dst *image.RGBA64, src image.Image) {
+	bounds := src.Bounds()
+	w := bounds.Dx()
+
+	switch m := src.(type) {
+	case *image.RGBA64:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			i, j := dst.PixOffset(bounds.Min.X, y), m.PixOffset(bounds.Min.X, y)
+			copy(dst.Pix[i:i+w*8], m.Pix[j:j+w*8])
+		}
+	case *image.RGBA:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			i, j := dst.PixOffset(bounds.Min.X, y), m.PixOffset(bounds.Min.X, y)
+			for x := 0; x < w; x, i, j = x+1, i+8, j+4 {
+				s := m.Pix[j : j+4 : j+4]
+				setPix(dst.Pix, i, uint16(s[0])*0x101, uint16(s[1])*0x101, uint16(s[2])*0x101, uint16(s[3])*0x101)
+			}
+		}
+	case *image.NRGBA:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			i, j := dst.PixOffset(bounds.Min.X, y), m.PixOffset(bounds.Min.X, y)
+			for x := 0; x < w; x, i, j = x+1, i+8, j+4 {
+				s := m.Pix[j : j+4 : j+4]
+				// Premultiply exactly like color.NRGBA.RGBA.
+				a := uint32(s[3]) * 0x101
+				setPix(dst.Pix, i,
+					uint16(uint32(s[0])*0x101*a/0xffff),
+					uint16(uint32(s[1])*0x101*a/0xffff),
+					uint16(uint32(s[2])*0x101*a/0xffff),
+					uint16(a))
+			}
+		}
+	case *image.NRGBA64:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			i, j := dst.PixOffset(bounds.Min.X, y), m.PixOffset(bounds.Min.X, y)
+			for x := 0; x < w; x, i, j = x+1, i+8, j+8 {
+				r, g, b, a := getPix(m.Pix, j)
+				a32 := uint32(a)
+				setPix(dst.Pix, i,
+					uint16(uint32(r)*a32/0xffff),
+					uint16(uint32(g)*a32/0xffff),
+					uint16(uint32(b)*a32/0xffff),
+					a)
+			}
+		}
+	case *image.Gray:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			i, j := dst.PixOffset(bounds.Min.X, y), m.PixOffset(bounds.Min.X, y)
+			for x := 0; x < w; x, i, j = x+1, i+8, j+1 {
+				v := uint16(m.Pix[j]) * 0x101
+				setPix(dst.Pix, i, v, v, v, 0xffff)
+			}
+		}
+	case *image.Gray16:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			i, j := dst.PixOffset(bounds.Min.X, y), m.PixOffset(bounds.Min.X, y)
+			for x := 0; x < w; x, i, j = x+1, i+8, j+2 {
+				v := uint16(m.Pix[j])<<8 | uint16(m.Pix[j+1])
+				setPix(dst.Pix, i, v, v, v, 0xffff)
+			}
+		}
+	case *image.Paletted:
+		palette := make([]color.RGBA64, len(m.Palette))
+		for k, c := range m.Palette {
+			palette[k] = color.RGBA64Model.Convert(c).(color.RGBA64)
+		}
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			i, j := dst.PixOffset(bounds.Min.X, y), m.PixOffset(bounds.Min.X, y)
+			for x := 0; x < w; x, i, j = x+1, i+8, j+1 {
+				var c color.RGBA64
+				if k := int(m.Pix[j]); k < len(palette) {
+					c = palette[k]
+				}
+				setPix(dst.Pix, i, c.R, c.G, c.B, c.A)
+			}
+		}
+	case image.RGBA64Image:
+		// Covers YCbCr, CMYK and the other standard types without boxing.
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			i := dst.PixOffset(bounds.Min.X, y)
+			for x := bounds.Min.X; x < bounds.Max.X; x, i = x+1, i+8 {
+				c := m.RGBA64At(x, y)
+				setPix(dst.Pix, i, c.R, c.G, c.B, c.A)
+			}
+		}
+	default:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				dst.SetRGBA64(x, y, color.RGBA64Model.Convert(src.At(x, y)).(color.RGBA64))
+			}
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// The reference implementations below are the At/Set versions the fast
+// paths replaced. They pin down the expected output and give the benchmarks
+// a baseline.
+
+func newImageReference(src image.Image) *image.RGBA64 {
+	bounds := src.Bounds()
+	dst := image.NewRGBA64(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := src.At(x, y).RGBA()
+			dst.Set(x, y, color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)})
+		}
+	}
+	return dst
+}
+
+func grayscaleReference(in, out *image.RGBA64) {
+	bounds := out.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := in.At(x, y).RGBA()
+			greyC := clamp(float64(r+g+b) / 3)
+			out.Set(x, y, color.RGBA64{greyC, greyC, greyC, uint16(a)})
+		}
+	}
+}
+
+func convolutionReference(in, out *image.RGBA64, kernel []float64) {
+	bounds := out.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var rSum, gSum, bSum, aSum float64
+			for ky := -1; ky <= 1; ky++ {
+				for kx := -1; kx <= 1; kx++ {
+					imgX := clampIndex(x+kx, bounds.Min.X, bounds.Max.X)
+					imgY := clampIndex(y+ky, bounds.Min.Y, bounds.Max.Y)
+					r, g, b, a := in.At(imgX, imgY).RGBA()
+					k := kernel[(ky+1)*3+(kx+1)]
+					rSum += float64(r) * k
+					gSum += float64(g) * k
+					bSum += float64(b) * k
+					aSum += float64(a) * k
+				}
+			}
+			a := clamp(math.Round(aSum))
+			out.Set(x, y, color.RGBA64{clampTo(rSum, a), clampTo(gSum, a), clampTo(bSum, a), a})
+		}
+	}
+}
+
+// testSources returns one image of every type copyInto has a fast path for,
+// plus one that only implements image.Image.
+func testSources() map[string]image.Image {
+	const w, h = 37, 23
+	rect := image.Rect(3, 5, 3+w, 5+h)
+	rgba := image.NewRGBA(rect)
+	nrgba := image.NewNRGBA(rect)
+	rgba64 := image.NewRGBA64(rect)
+	nrgba64 := image.NewNRGBA64(rect)
+	gray := image.NewGray(rect)
+	gray16 := image.NewGray16(rect)
+	paletted := image.NewPaletted(rect, color.Palette{
+		color.Black, color.White, color.NRGBA{255, 0, 0, 128}, color.Transparent,
+	})
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := color.NRGBA{uint8(x * 7), uint8(y * 11), uint8(x * y), uint8(x*13 + y)}
+			rgba.Set(x, y, c)
+			nrgba.Set(x, y, c)
+			rgba64.Set(x, y, c)
+			nrgba64.Set(x, y, c)
+			gray.Set(x, y, c)
+			gray16.Set(x, y, c)
+			paletted.SetColorIndex(x, y, uint8((x+y)%4))
+		}
+	}
+	for i := range ycbcr.Y {
+		ycbcr.Y[i] = uint8(i)
+	}
+	for i := range ycbcr.Cb {
+		ycbcr.Cb[i], ycbcr.Cr[i] = uint8(i*3), uint8(i*5)
+	}
+	return map[string]image.Image{
+		"RGBA": rgba, "NRGBA": nrgba, "RGBA64": rgba64, "NRGBA64": nrgba64,
+		"Gray": gray, "Gray16": gray16, "Paletted": paletted, "YCbCr": ycbcr,
+		"generic": struct{ image.Image }{nrgba},
+	}
+}
+
+func equalPix(a, b *image.RGBA64) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		for x := a.Rect.Min.X; x < a.Rect.Max.X; x++ {
+			if a.RGBA64At(x, y) != b.RGBA64At(x, y) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestCopyIntoMatchesAt(t *testing.T) {
+	for name, src := range testSources() {
+		if got, want := newImage(src).in, newImageReference(src); !equalPix(got, want) {
+			t.Errorf("%s: fast copy differs from At/RGBA", name)
+		}
+	}
+}
+
+func TestFastEffectsMatchReference(t *testing.T) {
+	src := testSources()["NRGBA"]
+	img := newImage(src)
+	img.Grayscale()
+	want := image.NewRGBA64(img.Bounds)
+	grayscaleReference(img.in, want)
+	if !equalPix(img.out, want) {
+		t.Error("Grayscale differs from the At/Set reference")
+	}
+
+	blur := []float64{1 / 9.0, 1 / 9.0, 1 / 9.0, 1 / 9.0, 1 / 9.0, 1 / 9.0, 1 / 9.0, 1 / 9.0, 1 / 9.0}
+	img.Convolution(blur)
+	convolutionReference(img.in, want, blur)
+	if !equalPix(img.out, want) {
+		t.Error("Convolution differs from the At/Set reference")
+	}
+}
+
+// benchImage is a 512x512 NRGBA image, the type the PNG decoder returns for
+// most photos with alpha.
+func benchImage() image.Image {
+	m := image.NewNRGBA(image.Rect(0, 0, 512, 512))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 31)
+	}
+	return m
+}
+
+func BenchmarkLoadCopy(b *testing.B) {
+	src := benchImage()
+	b.Run("fast", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			newImage(src)
+		}
+	})
+	b.Run("At", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			newImageReference(src)
+		}
+	})
+}
+
+func BenchmarkGrayscale(b *testing.B) {
+	img := newImage(benchImage())
+	b.Run("fast", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			img.Grayscale()
+		}
+	})
+	b.Run("At", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			grayscaleReference(img.in, img.out)
+		}
+	})
+}
+
+func BenchmarkConvolution(b *testing.B) {
+	img := newImage(benchImage())
+	sharpen := []float64{0, -1, 0, -1, 5, -1, 0, -1, 0}
+	b.Run("fast", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			img.Convolution(sharpen)
+		}
+	})
+	b.Run("At", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			convolutionReference(img.in, img.out, sharpen)
+		}
+	})
+}
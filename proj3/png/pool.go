@@ -0,0 +1,156 @@
+package png
+
+import (
+	"image"
+	"math/bits"
+	"sync"
+)
+
+// BufferPool recycles the pixel buffers of released images so that a run
+// processing thousands of images doesn't allocate two fresh full-size
+// buffers for each one. Buffers are kept in buckets by capacity, rounded up
+// to a power of two, so images of similar size share buffers.
+//
+// A BufferPool is safe for use by multiple goroutines.
+type BufferPool struct {
+	mu      sync.Mutex
+	buckets map[int][][]uint8
+	idle    int64 // bytes held in buckets
+	limit   int64 // upper bound for idle, 0 for no bound
+}
+
+// NewBufferPool returns a pool that keeps at most limit bytes of idle
+// buffers; buffers released beyond that are left to the garbage collector.
+// A limit of 0 means no limit.
+func NewBufferPool(limit int64) *BufferPool {
+	return &BufferPool{buckets: map[int][][]uint8{}, limit: limit}
+}
+
+// DefaultPool is the pool Load draws image buffers from.
+var DefaultPool = NewBufferPool(0)
+
+// SetIdleLimit changes the most idle bytes the pool keeps, dropping buffers if
+// it currently holds more.
+func (pool *BufferPool) SetIdleLimit(limit int64) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.limit = limit
+	if limit > 0 {
+		pool.trim(limit)
+	}
+}
+
+// Trim drops idle buffers until the pool holds at most max idle bytes,
+// without changing its limit. A nil pool holds none.
+func (pool *BufferPool) Trim(max int64) {
+	if pool == nil {
+		return
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.trim(max)
+}
+
+// trim drops idle buffers until at most max bytes are idle. pool.mu must be
+// held.
+func (pool *BufferPool) trim(max int64) {
+	for bucket, bufs := range pool.buckets {
+		for len(bufs) > 0 && pool.idle > max {
+			pool.idle -= int64(cap(bufs[len(bufs)-1]))
+			bufs = bufs[:len(bufs)-1]
+		}
+		pool.buckets[bucket] = bufs
+	}
+}
+
+// Idle returns the number of bytes of buffers waiting in the pool.
+func (pool *BufferPool) Idle() int64 {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return pool.idle
+}
+
+// bucketOf returns the bucket for a buffer of n bytes.
+func bucketOf(n int) int {
+	if n <= 1 {
+		return 0
+	}
+	return bits.Len(uint(n - 1))
+}
+
+// Get returns a zeroed RGBA64 image covering bounds, reusing a pooled buffer
+// when one is big enough. A nil pool always allocates.
+func (pool *BufferPool) Get(bounds image.Rectangle) *image.RGBA64 {
+	n := bounds.Dx() * bounds.Dy() * 8
+	if pool == nil || n == 0 {
+		return image.NewRGBA64(bounds)
+	}
+	bucket := bucketOf(n)
+
+	pool.mu.Lock()
+	var pix []uint8
+	if bufs := pool.buckets[bucket]; len(bufs) > 0 {
+		pix = bufs[len(bufs)-1]
+		pool.buckets[bucket] = bufs[:len(bufs)-1]
+		pool.idle -= int64(cap(pix))
+	}
+	pool.mu.Unlock()
+
+	if pix == nil {
+		pix = make([]uint8, n, 1<<bucket)
+	} else {
+		pix = pix[:n]
+		for i := range pix {
+			pix[i] = 0
+		}
+	}
+	return &image.RGBA64{Pix: pix, Stride: bounds.Dx() * 8, Rect: bounds}
+}
+
+// Put hands the buffer of m back to the pool. m must not be used afterwards.
+func (pool *BufferPool) Put(m *image.RGBA64) {
+	if pool == nil || m == nil || cap(m.Pix) == 0 {
+		return
+	}
+	c := cap(m.Pix)
+	bucket := bucketOf(c)
+	if 1<<bucket != c {
+		// Not allocated by Get, so it would be too small for some requests
+		// in its bucket.
+		return
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.limit > 0 && pool.idle+int64(c) > pool.limit {
+		return
+	}
+	pool.buckets[bucket] = append(pool.buckets[bucket], m.Pix[:0])
+	pool.idle += int64(c)
+}
+
+// newBuffer returns a buffer covering bounds from the image's pool.
+func (img *Image) newBuffer(bounds image.Rectangle) *image.RGBA64 {
+	return img.pool.Get(bounds)
+}
+
+// replaceOut swaps the output buffer for one covering bounds, for effects
+// that change the size of the image, and updates Bounds.
+func (img *Image) replaceOut(bounds image.Rectangle) *image.RGBA64 {
+	if img.out != img.in {
+		img.pool.Put(img.out)
+	}
+	img.out = img.newBuffer(bounds)
+	img.Bounds = bounds
+	return img.out
+}
+
+// Release returns the image's buffers to the pool they came from. Call it
+// once the image has been saved; the image must not be used afterwards.
+func (img *Image) Release() {
+	img.pool.Put(img.in)
+	if img.out != img.in {
+		img.pool.Put(img.out)
+	}
+	img.in, img.out = nil, nil
+}
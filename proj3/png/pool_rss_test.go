@@ -0,0 +1,122 @@
+//go:build linux && !race
+
+package png
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// The peak RSS test runs the same workload twice in child processes, once
+// drawing buffers from a pool and once allocating them, and compares the
+// most memory each child held. It uses the big dataset when it is there
+// (../../data/in/big, or $PNG_RSS_DATASET) and a synthetic stand-in of
+// large images otherwise. It takes tens of seconds, so -short skips it, and
+// the race detector's own memory would swamp the difference.
+
+const rssWorkers = 4
+
+// rssDataset returns the images to process, writing the synthetic set into
+// a temporary directory when the big dataset is missing.
+func rssDataset(t *testing.T) []string {
+	dir := os.Getenv("PNG_RSS_DATASET")
+	if dir == "" {
+		dir = filepath.Join("..", "..", "data", "in", "big")
+	}
+	if paths, _ := filepath.Glob(filepath.Join(dir, "*.png")); len(paths) > 0 {
+		return paths
+	}
+	dir = t.TempDir()
+	var paths []string
+	for i := 0; i < 2*rssWorkers; i++ {
+		m := image.NewNRGBA(image.Rect(0, 0, 1600+16*i, 1200))
+		for y := 0; y < m.Rect.Dy(); y++ {
+			for x := 0; x < m.Rect.Dx(); x++ {
+				o := m.PixOffset(x, y)
+				m.Pix[o], m.Pix[o+1], m.Pix[o+2], m.Pix[o+3] = uint8(x), uint8(y), uint8(x^y), 255
+			}
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%d.png", i))
+		writePNG(t, path, m)
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// TestPeakRSSChild is the workload of TestPoolPeakRSS. It only runs
+// in the child processes, with the images listed in $PNG_RSS_IMAGES.
+func TestPeakRSSChild(t *testing.T) {
+	mode := os.Getenv("PNG_RSS_MODE")
+	if mode == "" {
+		t.Skip("run by TestPoolPeakRSS")
+	}
+	if mode == "unpooled" {
+		DefaultPool = nil
+	}
+	paths := filepath.SplitList(os.Getenv("PNG_RSS_IMAGES"))
+	out := t.TempDir()
+
+	// As under work stealing, a few workers take images until none are left,
+	// and every image is processed twice to let the pool warm up.
+	jobs := make(chan string)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < rssWorkers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for path := range jobs {
+				img, err := Load(path)
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				img.RunEffects([]string{"B", "S"})
+				if err := img.Save(filepath.Join(out, fmt.Sprintf("%d.png", w))); err != nil {
+					t.Error(err)
+				}
+				img.Release()
+			}
+		}(w)
+	}
+	for round := 0; round < 2; round++ {
+		for _, path := range paths {
+			jobs <- path
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// peakRSS runs the workload in a child process in mode and returns its peak
+// resident set size in bytes.
+func peakRSS(t *testing.T, mode string, paths []string) int64 {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPeakRSSChild$")
+	cmd.Env = append(os.Environ(), "PNG_RSS_MODE="+mode, "PNG_RSS_IMAGES="+strings.Join(paths, string(filepath.ListSeparator)))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s child: %v\n%s", mode, err, output)
+	}
+	// Linux reports ru_maxrss in KiB.
+	return cmd.ProcessState.SysUsage().(*syscall.Rusage).Maxrss * 1024
+}
+
+func TestPoolPeakRSS(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs two child processes over large images")
+	}
+	paths := rssDataset(t)
+	unpooled := peakRSS(t, "unpooled", paths)
+	pooled := peakRSS(t, "pooled", paths)
+	t.Logf("%d images, %d workers: peak RSS %.1f MiB without the pool, %.1f MiB with it",
+		len(paths), rssWorkers, float64(unpooled)/(1<<20), float64(pooled)/(1<<20))
+	// GC timing moves the peak by a few percent from run to run.
+	if pooled > unpooled+unpooled/20 {
+		t.Errorf("pooling raised the peak RSS")
+	}
+}
@@ -0,0 +1,140 @@
+package png
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestPoolReuse(t *testing.T) {
+	pool := NewBufferPool(0)
+	m := pool.Get(image.Rect(0, 0, 10, 10))
+	if len(m.Pix) != 800 || m.Stride != 80 {
+		t.Fatalf("got %d bytes with stride %d, want 800 and 80", len(m.Pix), m.Stride)
+	}
+	m.Pix[0] = 0xff
+	pool.Put(m)
+	if got := pool.Idle(); got != 1024 {
+		t.Fatalf("idle %d bytes, want 1024", got)
+	}
+
+	// A slightly smaller image falls in the same bucket and gets the same,
+	// cleared, buffer.
+	n := pool.Get(image.Rect(5, 5, 14, 16))
+	if &n.Pix[:1][0] != &m.Pix[:1][0] {
+		t.Error("buffer was not reused")
+	}
+	if n.Pix[0] != 0 {
+		t.Error("reused buffer was not cleared")
+	}
+	if n.Bounds() != image.Rect(5, 5, 14, 16) || n.PixOffset(5, 5) != 0 {
+		t.Errorf("reused buffer has bounds %v", n.Bounds())
+	}
+	if got := pool.Idle(); got != 0 {
+		t.Errorf("idle %d bytes after Get, want 0", got)
+	}
+}
+
+func TestPoolIdleLimit(t *testing.T) {
+	pool := NewBufferPool(2048)
+	for i := 0; i < 3; i++ {
+		pool.Put(pool.Get(image.Rect(0, 0, 128, 1)))
+	}
+	bufs := []*image.RGBA64{}
+	for i := 0; i < 3; i++ {
+		bufs = append(bufs, pool.Get(image.Rect(0, 0, 128, 1)))
+	}
+	for _, m := range bufs {
+		pool.Put(m)
+	}
+	if got := pool.Idle(); got != 2048 {
+		t.Errorf("idle %d bytes, want the limit of 2048", got)
+	}
+	pool.SetIdleLimit(1024)
+	if got := pool.Idle(); got != 1024 {
+		t.Errorf("idle %d bytes after SetIdleLimit, want 1024", got)
+	}
+
+	// Buffers the pool did not allocate are never kept.
+	pool.SetIdleLimit(0)
+	pool.Put(image.NewRGBA64(image.Rect(0, 0, 3, 3)))
+	if got := pool.Idle(); got != 1024 {
+		t.Errorf("idle %d bytes after Put of a foreign buffer, want 1024", got)
+	}
+
+	pool.Trim(1000)
+	if got := pool.Idle(); got != 0 {
+		t.Errorf("idle %d bytes after Trim(1000), want 0", got)
+	}
+	var nilPool *BufferPool
+	nilPool.Trim(0)
+}
+
+func TestReleaseWithoutEffects(t *testing.T) {
+	saved := DefaultPool
+	DefaultPool = NewBufferPool(0)
+	defer func() { DefaultPool = saved }()
+
+	img := loadThroughFile(t, gradient())
+	img.RunEffects(nil)
+	img.Release()
+	// Both buffers come back exactly once even though in and out alias.
+	size := int64(1 << bucketOf(img.Bounds.Dx()*img.Bounds.Dy()*8))
+	if got := DefaultPool.Idle(); got != 2*size {
+		t.Errorf("idle %d bytes, want %d", got, 2*size)
+	}
+}
+
+// allocatedPerImage returns the bytes allocated on average to load, process
+// and save an image with Release called after each save.
+func allocatedPerImage(t *testing.T, effects ...string) uint64 {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.png"), filepath.Join(dir, "out.png")
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, benchImage()); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	const n = 20
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for i := 0; i < n; i++ {
+		img, err := Load(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img.RunEffects(effects)
+		if err := img.Save(out); err != nil {
+			t.Fatal(err)
+		}
+		img.Release()
+	}
+	runtime.ReadMemStats(&after)
+	return (after.TotalAlloc - before.TotalAlloc) / n
+}
+
+func TestPoolReducesAllocation(t *testing.T) {
+	saved := DefaultPool
+	defer func() { DefaultPool = saved }()
+
+	for _, effects := range [][]string{{"B", "G"}, {"resize:w=300", "S"}} {
+		DefaultPool = nil
+		unpooled := allocatedPerImage(t, effects...)
+		DefaultPool = NewBufferPool(0)
+		pooled := allocatedPerImage(t, effects...)
+		t.Logf("%v: %d bytes per image without the pool, %d with it", effects, unpooled, pooled)
+		// The image buffers are the bulk of what an image costs beyond the
+		// decoder and encoder, and the pool should hand them out again.
+		if diff := unpooled - pooled; pooled > unpooled || diff < uint64(benchImage().Bounds().Dx()*benchImage().Bounds().Dy()*8) {
+			t.Errorf("%v: pool saved %d bytes per image", effects, int64(unpooled)-int64(pooled))
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package png
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Filter selects the resampling kernel used when changing the size of an image.
+type Filter int
+
+const (
+	Nearest Filter = iota
+	Bilinear
+	Bicubic
+	Lanczos
+)
+
+// ParseFilter returns the Filter named by name ("nearest", "bilinear",
+// "bicubic" or "lanczos").
+func ParseFilter(name string) (Filter, error) {
+	switch name {
+	case "nearest":
+		return Nearest, nil
+	case "bilinear":
+		return Bilinear, nil
+	case "bicubic":
+		return Bicubic, nil
+	case "lanczos":
+		return Lanczos, nil
+	}
+	return 0, fmt.Errorf("unknown resampling filter %q", name)
+}
+
+// support returns the radius of the filter kernel in source pixels at a
+// scale of 1.
+func (f Filter) support() float64 {
+	switch f {
+	case Bilinear:
+		return 1
+	case Bicubic:
+		return 2
+	case Lanczos:
+		return 3
+	}
+	return 0.5
+}
+
+// weight evaluates the filter kernel at distance t from the sample centre.
+func (f Filter) weight(t float64) float64 {
+	t = math.Abs(t)
+	switch f {
+	case Bilinear:
+		if t < 1 {
+			return 1 - t
+		}
+		return 0
+	case Bicubic:
+		// Catmull-Rom spline (a = -0.5)
+		if t < 1 {
+			return (1.5*t-2.5)*t*t + 1
+		}
+		if t < 2 {
+			return ((-0.5*t+2.5)*t-4)*t + 2
+		}
+		return 0
+	case Lanczos:
+		if t == 0 {
+			return 1
+		}
+		if t < 3 {
+			pt := math.Pi * t
+			return 3 * math.Sin(pt) * math.Sin(pt/3) / (pt * pt)
+		}
+		return 0
+	}
+	if t <= 0.5 {
+		return 1
+	}
+	return 0
+}
+
+// tap is a single source index and its normalised weight.
+type tap struct {
+	index  int
+	weight float64
+}
+
+// contributions returns, for each of the dstLen output samples, the source
+// samples in [srcMin, srcMax) that contribute to it. The output covers the
+// source interval [from, from+length).
+func contributions(f Filter, dstLen, srcMin, srcMax int, from, length float64) [][]tap {
+	scale := length / float64(dstLen)
+	// When shrinking, widen the kernel so every source pixel contributes.
+	filterScale := math.Max(scale, 1)
+	radius := f.support() * filterScale
+	result := make([][]tap, dstLen)
+
+	for i := 0; i < dstLen; i++ {
+		center := from + (float64(i)+0.5)*scale
+		if f == Nearest {
+			idx := int(math.Floor(center))
+			result[i] = []tap{{clampIndex(idx, srcMin, srcMax), 1}}
+			continue
+		}
+
+		lo := int(math.Floor(center - radius))
+		hi := int(math.Ceil(center + radius))
+		taps := make([]tap, 0, hi-lo+1)
+		sum := 0.0
+		for s := lo; s <= hi; s++ {
+			w := f.weight((float64(s) + 0.5 - center) / filterScale)
+			if w == 0 {
+				continue
+			}
+			taps = append(taps, tap{clampIndex(s, srcMin, srcMax), w})
+			sum += w
+		}
+		if sum != 0 {
+			for t := range taps {
+				taps[t].weight /= sum
+			}
+		}
+		result[i] = taps
+	}
+	return result
+}
+
+// tapBytes returns about how many bytes contributions allocates for dstLen
+// samples covering length source samples: a slice per sample, with room for
+// the taps of the widened kernel.
+func tapBytes(f Filter, dstLen int, length float64) int64 {
+	taps := 1
+	if f != Nearest {
+		taps = int(math.Ceil(2*f.support()*math.Max(length/float64(dstLen), 1))) + 3
+	}
+	return int64(dstLen) * (24 + 16*int64(taps))
+}
+
+// clampIndex clamps i into [min, max) so that samples beyond the edge repeat
+// the border pixel.
+func clampIndex(i, min, max int) int {
+	if i < min {
+		return min
+	}
+	if i >= max {
+		return max - 1
+	}
+	return i
+}
+
+// resample scales the region src (in source pixel coordinates) of the input
+// image to a new width x height output buffer and updates Bounds.
+func (img *Image) resample(src [4]float64, width, height int, filter Filter) {
+	inBounds := img.in.Bounds()
+	srcX, srcY, srcW, srcH := src[0], src[1], src[2], src[3]
+	cols := contributions(filter, width, inBounds.Min.X, inBounds.Max.X, srcX, srcW)
+	rows := contributions(filter, height, inBounds.Min.Y, inBounds.Max.Y, srcY, srcH)
+
+	// Horizontal pass into an intermediate buffer holding every source row.
+	inH := inBounds.Dy()
+	tmp := make([]float64, inH*width*4)
+	for y := inBounds.Min.Y; y < inBounds.Max.Y; y++ {
+		row := tmp[(y-inBounds.Min.Y)*width*4:]
+		for x, taps := range cols {
+			var r, g, b, a float64
+			for _, t := range taps {
+				c := img.in.RGBA64At(t.index, y)
+				r += float64(c.R) * t.weight
+				g += float64(c.G) * t.weight
+				b += float64(c.B) * t.weight
+				a += float64(c.A) * t.weight
+			}
+			row[x*4], row[x*4+1], row[x*4+2], row[x*4+3] = r, g, b, a
+		}
+	}
+
+	// Vertical pass into the resized output.
+	bounds := image.Rect(0, 0, width, height)
+	out := img.replaceOut(bounds)
+	for y, taps := range rows {
+		for x := 0; x < width; x++ {
+			var r, g, b, a float64
+			for _, t := range taps {
+				i := ((t.index-inBounds.Min.Y)*width + x) * 4
+				r += tmp[i] * t.weight
+				g += tmp[i+1] * t.weight
+				b += tmp[i+2] * t.weight
+				a += tmp[i+3] * t.weight
+			}
+			// Negative kernel lobes can push a colour above its
+			// (premultiplied) alpha, so bound it by alpha.
+			alpha := clamp(math.Round(a))
+			out.SetRGBA64(x, y, color.RGBA64{
+				clampTo(math.Round(r), alpha), clampTo(math.Round(g), alpha), clampTo(math.Round(b), alpha), alpha,
+			})
+		}
+	}
+}
+
+// clampTo clamps comp into [0, max].
+func clampTo(comp float64, max uint16) uint16 {
+	// Plain comparisons: math.Min and math.Max handle NaN and signed zeros,
+	// which makes them too slow for per-channel use.
+	if comp <= 0 {
+		return 0
+	}
+	if comp >= float64(max) {
+		return max
+	}
+	return uint16(comp)
+}
+
+// Resize scales the image to exactly width x height pixels. If one of width or
+// height is zero it is derived from the other so the aspect ratio is kept.
+func (img *Image) Resize(width, height int, filter Filter) {
+	b := img.in.Bounds()
+	width, height = aspectSize(b, width, height)
+	img.resample(window(b), width, height, filter)
+}
+
+// Scale resizes the image by factor in both dimensions.
+func (img *Image) Scale(factor float64, filter Filter) {
+	width, height := scaledSize(img.in.Bounds(), factor)
+	img.Resize(width, height, filter)
+}
+
+// Fit resizes the image to the largest size that fits inside a width x height
+// box while keeping its aspect ratio.
+func (img *Image) Fit(width, height int, filter Filter) {
+	w, h := fitSize(img.in.Bounds(), width, height)
+	img.Resize(w, h, filter)
+}
+
+// Fill resizes the image to cover a width x height box while keeping its
+// aspect ratio, cropping the overflow equally from both sides so the result
+// is exactly width x height.
+func (img *Image) Fill(width, height int, filter Filter) {
+	img.resample(fillWindow(img.in.Bounds(), width, height), width, height, filter)
+}
+
+// window returns the whole of bounds as a resample source region.
+func window(bounds image.Rectangle) [4]float64 {
+	return [4]float64{float64(bounds.Min.X), float64(bounds.Min.Y), float64(bounds.Dx()), float64(bounds.Dy())}
+}
+
+// fillWindow returns the centred part of bounds with the aspect ratio of a
+// width x height box.
+func fillWindow(bounds image.Rectangle, width, height int) [4]float64 {
+	srcW, srcH := float64(bounds.Dx()), float64(bounds.Dy())
+	factor := math.Max(float64(width)/srcW, float64(height)/srcH)
+	cropW, cropH := float64(width)/factor, float64(height)/factor
+	return [4]float64{
+		float64(bounds.Min.X) + (srcW-cropW)/2,
+		float64(bounds.Min.Y) + (srcH-cropH)/2,
+		cropW,
+		cropH,
+	}
+}
+
+// scaledSize returns the size of bounds scaled by factor.
+func scaledSize(bounds image.Rectangle, factor float64) (int, int) {
+	return int(math.Max(1, math.Round(float64(bounds.Dx())*factor))), int(math.Max(1, math.Round(float64(bounds.Dy())*factor)))
+}
+
+// maxPixels is the largest image, in pixels, that an effect may create: an
+// RGBA64 buffer of this size takes 2 GiB.
+const maxPixels = 1 << 28
+
+// checkSize returns an error if a width x height output of effect is larger
+// than maxPixels.
+func checkSize(effect string, width, height float64) error {
+	if width > maxPixels || height > maxPixels || width*height > maxPixels {
+		return fmt.Errorf("%s: output of %.0fx%.0f pixels is larger than the limit of %d", effect, width, height, maxPixels)
+	}
+	return nil
+}
+
+// fitSize returns the largest size with the aspect ratio of bounds that fits
+// inside a width x height box.
+func fitSize(bounds image.Rectangle, width, height int) (int, int) {
+	factor := math.Min(float64(width)/float64(bounds.Dx()), float64(height)/float64(bounds.Dy()))
+	w := int(math.Max(1, math.Round(float64(bounds.Dx())*factor)))
+	h := int(math.Max(1, math.Round(float64(bounds.Dy())*factor)))
+	return w, h
+}
+
+// aspectSize fills in a zero width or height from the aspect ratio of bounds.
+func aspectSize(bounds image.Rectangle, width, height int) (int, int) {
+	if width <= 0 && height <= 0 {
+		return bounds.Dx(), bounds.Dy()
+	}
+	if width <= 0 {
+		width = int(math.Max(1, math.Round(float64(height)*float64(bounds.Dx())/float64(bounds.Dy()))))
+	}
+	if height <= 0 {
+		height = int(math.Max(1, math.Round(float64(width)*float64(bounds.Dy())/float64(bounds.Dx()))))
+	}
+	return width, height
+}
+
+// resizing is a parsed "resize" entry: the region of the input to resample
+// and the size and filter to resample it to.
+type resizing struct {
+	src           [4]float64
+	width, height int
+	filter        Filter
+}
+
+// parseResize reads a "resize" entry for an input covering bounds. Accepted
+// arguments:
+//
+//	w, h    target size; a missing side keeps the aspect ratio
+//	scale   scale factor, used instead of w and h
+//	mode    "exact" (default), "fit" or "fill" the w x h box
+//	filter  nearest, bilinear, bicubic (default) or lanczos
+func parseResize(spec effectSpec, bounds image.Rectangle) (resizing, error) {
+	r := resizing{src: window(bounds)}
+	var err error
+	if r.filter, err = ParseFilter(spec.str("filter", "bicubic")); err != nil {
+		return r, err
+	}
+	if spec.has("scale") {
+		factor, err := spec.float("scale", 1)
+		if err != nil {
+			return r, err
+		}
+		if factor <= 0 {
+			return r, fmt.Errorf("resize: scale must be positive, got %v", factor)
+		}
+		if err := checkSize("resize", float64(bounds.Dx())*factor, float64(bounds.Dy())*factor); err != nil {
+			return r, err
+		}
+		r.width, r.height = scaledSize(bounds, factor)
+		return r, nil
+	}
+
+	width, err := spec.int("w", 0)
+	if err != nil {
+		return r, err
+	}
+	height, err := spec.int("h", 0)
+	if err != nil {
+		return r, err
+	}
+	if width < 0 || height < 0 || (width == 0 && height == 0) {
+		return r, fmt.Errorf("resize: need a positive w, h or scale")
+	}
+	// Bound each side first so that the sizes derived from them cannot
+	// overflow.
+	if width > maxPixels || height > maxPixels {
+		return r, fmt.Errorf("resize: w and h must be at most %d", maxPixels)
+	}
+
+	mode := spec.str("mode", "exact")
+	if mode != "exact" && (width == 0 || height == 0) {
+		return r, fmt.Errorf("resize: mode=%s needs both w and h", mode)
+	}
+	switch mode {
+	case "exact":
+		r.width, r.height = aspectSize(bounds, width, height)
+	case "fit":
+		r.width, r.height = fitSize(bounds, width, height)
+	case "fill":
+		r.width, r.height = width, height
+		r.src = fillWindow(bounds, width, height)
+	default:
+		return r, fmt.Errorf("resize: unknown mode %q", mode)
+	}
+	return r, checkSize("resize", float64(r.width), float64(r.height))
+}
+
+// scratch returns about how many bytes resample allocates for r on an input
+// covering bounds besides the output buffer: the float64 intermediate image
+// of every input row at the output width, and the filter taps.
+func (r resizing) scratch(bounds image.Rectangle) int64 {
+	return 32*int64(bounds.Dy())*int64(r.width) +
+		tapBytes(r.filter, r.width, r.src[2]) + tapBytes(r.filter, r.height, r.src[3])
+}
+
+// runResize applies a "resize" entry from effects.txt (see parseResize).
+func (img *Image) runResize(spec effectSpec) error {
+	r, err := parseResize(spec, img.in.Bounds())
+	if err != nil {
+		return err
+	}
+	img.resample(r.src, r.width, r.height, r.filter)
+	return nil
+}
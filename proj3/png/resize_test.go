@@ -0,0 +1,87 @@
+package png
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// solid returns a width x height image of a single colour.
+func solid(width, height int, c color.Color) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestResizeSize(t *testing.T) {
+	tests := []struct {
+		effect        string
+		width, height int
+	}{
+		{"resize:w=40,h=10", 40, 10},
+		{"resize:w=30", 30, 15},
+		{"resize:h=5", 10, 5},
+		{"resize:scale=0.5", 10, 5},
+		{"resize:scale=1.5", 30, 15},
+		{"resize:w=40,h=40,mode=fit", 40, 20},
+		{"resize:w=10,h=30,mode=fit", 10, 5},
+		{"resize:w=40,h=40,mode=fill", 40, 40},
+		{"resize:w=5,h=30,mode=fill", 5, 30},
+		{"resize:w=1000,h=1,mode=fit", 2, 1},
+	}
+	for _, test := range tests {
+		for _, filter := range []string{"nearest", "bilinear", "bicubic", "lanczos"} {
+			effect := test.effect + ",filter=" + filter
+			out := runOn(solid(20, 10, color.NRGBA{200, 100, 50, 255}), false, effect)
+			if got := out.Bounds(); got != image.Rect(0, 0, test.width, test.height) {
+				t.Errorf("%s: bounds %v, want %dx%d", effect, got, test.width, test.height)
+				continue
+			}
+			// The kernels are normalised, so a solid image stays solid.
+			want := color.RGBA64Model.Convert(color.NRGBA{200, 100, 50, 255}).(color.RGBA64)
+			if c := out.RGBA64At(test.width-1, test.height-1); c != want {
+				t.Errorf("%s: corner %v, want %v", effect, c, want)
+			}
+		}
+	}
+}
+
+func TestResizeLimit(t *testing.T) {
+	for _, effect := range []string{
+		"resize:scale=1e6",
+		"resize:w=2000000000",
+		"resize:h=9000000000000000000",
+		"resize:w=100000,h=100000,mode=fit",
+		"resize:w=20000,h=20000,mode=fill",
+	} {
+		img := newImage(solid(20, 10, color.White))
+		if err := img.applyEffect(effect); err == nil {
+			t.Errorf("%q: no error", effect)
+		}
+	}
+	// Only the fitted size counts, not the box.
+	img := newImage(solid(20, 10, color.White))
+	if err := img.applyEffect("resize:w=1000000,h=10,mode=fit"); err != nil {
+		t.Errorf("fit into a large box: %v", err)
+	}
+}
+
+func TestAspectSize(t *testing.T) {
+	bounds := image.Rect(0, 0, 300, 200)
+	tests := []struct{ w, h, wantW, wantH int }{
+		{0, 0, 300, 200},
+		{150, 0, 150, 100},
+		{0, 50, 75, 50},
+		{1, 0, 1, 1},
+		{40, 70, 40, 70},
+	}
+	for _, test := range tests {
+		if w, h := aspectSize(bounds, test.w, test.h); w != test.wantW || h != test.wantH {
+			t.Errorf("aspectSize(%d, %d) = %d, %d, want %d, %d", test.w, test.h, w, h, test.wantW, test.wantH)
+		}
+	}
+}
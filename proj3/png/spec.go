@@ -0,0 +1,136 @@
+package png
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// effectSpec is a single entry of a request's effects list. Besides the
+// original one letter effects ("G", "S", "E", "B") an entry can name an effect
+// followed by comma separated key=value arguments, e.g.
+//
+//	"resize:w=200,h=100,filter=lanczos"
+type effectSpec struct {
+	name string
+	args map[string]string
+}
+
+// parseEffect splits an effects.txt entry into its name and arguments.
+func parseEffect(effect string) (effectSpec, error) {
+	spec := effectSpec{args: map[string]string{}}
+	name, rest, hasArgs := strings.Cut(effect, ":")
+	spec.name = strings.TrimSpace(name)
+	if spec.name == "" {
+		return spec, fmt.Errorf("empty effect name in %q", effect)
+	}
+	if !hasArgs {
+		return spec, nil
+	}
+	for _, field := range strings.Split(rest, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return spec, fmt.Errorf("effect %q: argument %q is not key=value", spec.name, field)
+		}
+		spec.args[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return spec, nil
+}
+
+// has reports whether the argument key was given.
+func (spec effectSpec) has(key string) bool {
+	_, ok := spec.args[key]
+	return ok
+}
+
+// str returns the argument key, or def when it was not given.
+func (spec effectSpec) str(key, def string) string {
+	if value, ok := spec.args[key]; ok {
+		return value
+	}
+	return def
+}
+
+// float returns the argument key parsed as a finite float64, or def when it
+// was not given.
+func (spec effectSpec) float(key string, def float64) (float64, error) {
+	value, ok := spec.args[key]
+	if !ok {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("effect %q: argument %s=%q is not a finite number", spec.name, key, value)
+	}
+	return f, nil
+}
+
+// int returns the argument key parsed as an int, or def when it was not given.
+func (spec effectSpec) int(key string, def int) (int, error) {
+	value, ok := spec.args[key]
+	if !ok {
+		return def, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("effect %q: argument %s=%q is not an integer", spec.name, key, value)
+	}
+	return i, nil
+}
+
+// color returns the argument key parsed as a hex colour ("rrggbb" or
+// "rrggbbaa", optionally prefixed with '#'), or def when it was not given.
+// The result is premultiplied like the pixels of Image.
+func (spec effectSpec) color(key string, def color.RGBA64) (color.RGBA64, error) {
+	value, ok := spec.args[key]
+	if !ok {
+		return def, nil
+	}
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 8 || err != nil {
+		return def, fmt.Errorf("effect %q: argument %s=%q is not a rrggbb[aa] colour", spec.name, key, value)
+	}
+	c := color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}
+	r, g, b, a := c.RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}, nil
+}
+
+// floats returns the argument key parsed as a list of finite numbers
+// separated by spaces.
+func (spec effectSpec) floats(key string) ([]float64, error) {
+	var result []float64
+	for _, field := range strings.Fields(spec.args[key]) {
+		f, err := strconv.ParseFloat(field, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("effect %q: argument %s contains %q, which is not a finite number", spec.name, key, field)
+		}
+		result = append(result, f)
+	}
+	return result, nil
+}
+
+// points returns the argument key parsed as n points "x0 y0 x1 y1 ...".
+func (spec effectSpec) points(key string, n int) ([]Point, error) {
+	coords, err := spec.floats(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(coords) != 2*n {
+		return nil, fmt.Errorf("effect %q: argument %s needs %d points, got %d numbers", spec.name, key, n, len(coords))
+	}
+	points := make([]Point, n)
+	for i := range points {
+		points[i] = Point{coords[2*i], coords[2*i+1]}
+	}
+	return points, nil
+}
@@ -0,0 +1,661 @@
+package png
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+)
+
+// A row at a time PNG reader and writer for the strip mode in tiled.go. The
+// standard library decodes and encodes whole images only, which is what
+// strip mode exists to avoid. Load uses the reader too, to decode straight
+// into a pooled buffer.
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// PNG colour types.
+const (
+	ctGray      = 0
+	ctTrueColor = 2
+	ctPaletted  = 3
+	ctGrayAlpha = 4
+	ctRGBA      = 6
+)
+
+// rowDecoder reads the rows of a non-interlaced PNG one at a time and
+// converts them to premultiplied RGBA64 pixels exactly as Load would.
+type rowDecoder struct {
+	width, height int
+	depth         int
+	colorType     int
+
+	palette     [256][4]uint16 // premultiplied
+	transparent []byte         // tRNS of gray and truecolor images
+	bpp         int            // bytes per complete pixel, for the filters
+
+	idat *idatReader
+	zr   io.ReadCloser
+	cur  []byte
+	prev []byte
+}
+
+// idatReader presents the data of consecutive IDAT chunks as one stream.
+// Once it is done, next and nextLength hold the header of the chunk that
+// follows them.
+type idatReader struct {
+	r          *bufio.Reader
+	remaining  uint32
+	crc        hash.Hash32
+	done       bool
+	next       string
+	nextLength uint32
+}
+
+func (d *idatReader) Read(p []byte) (int, error) {
+	for d.remaining == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.verifyChecksum(); err != nil {
+			return 0, err
+		}
+		length, kind, err := d.chunkHeader()
+		if err != nil {
+			return 0, err
+		}
+		if kind != "IDAT" {
+			// The image data is over; close reads the rest.
+			d.done, d.next, d.nextLength = true, kind, length
+			return 0, io.EOF
+		}
+		d.remaining = length
+	}
+	if uint32(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n, err := d.r.Read(p)
+	d.crc.Write(p[:n])
+	d.remaining -= uint32(n)
+	return n, err
+}
+
+// chunkHeader reads the length and type of the next chunk and starts its
+// checksum.
+func (d *idatReader) chunkHeader() (uint32, string, error) {
+	var header [8]byte
+	if _, err := io.ReadFull(d.r, header[:]); err != nil {
+		return 0, "", err
+	}
+	d.crc.Reset()
+	d.crc.Write(header[4:])
+	return binary.BigEndian.Uint32(header[:4]), string(header[4:]), nil
+}
+
+// verifyChecksum reads the CRC that ends the current chunk.
+func (d *idatReader) verifyChecksum() error {
+	var footer [4]byte
+	if _, err := io.ReadFull(d.r, footer[:]); err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(footer[:]) != d.crc.Sum32() {
+		return png.FormatError("invalid checksum")
+	}
+	return nil
+}
+
+// skip checksums the length bytes of the current chunk without keeping them.
+func (d *idatReader) skip(length uint32) error {
+	if length > 1<<31-1 {
+		return png.FormatError("chunk too long")
+	}
+	if _, err := io.CopyN(d.crc, d.r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return d.verifyChecksum()
+}
+
+// chunkLimits are the chunks newRowDecoder reads before the image data,
+// with the most bytes each may hold.
+var chunkLimits = map[string]uint32{"IHDR": 13, "PLTE": 3 * 256, "tRNS": 256}
+
+// newRowDecoder reads the chunks of r up to the image data. Interlaced
+// images return ErrNotTileable, since their rows arrive in seven passes.
+func newRowDecoder(r io.Reader) (*rowDecoder, error) {
+	br := bufio.NewReader(r)
+	var sig [8]byte
+	if _, err := io.ReadFull(br, sig[:]); err != nil {
+		return nil, err
+	}
+	if string(sig[:]) != pngHeader {
+		return nil, fmt.Errorf("%w: not a PNG file", ErrNotTileable)
+	}
+
+	d := &rowDecoder{idat: &idatReader{r: br, crc: crc32.NewIEEE()}}
+	for i := range d.palette {
+		d.palette[i] = [4]uint16{0, 0, 0, 0xffff}
+	}
+	seen := map[string]bool{}
+	for {
+		length, kind, err := d.idat.chunkHeader()
+		if err != nil {
+			return nil, err
+		}
+		// Reject the chunk orders image/png does.
+		outOfOrder := !seen["IHDR"] && kind != "IHDR"
+		switch kind {
+		case "IHDR":
+			outOfOrder = seen["IHDR"]
+		case "PLTE":
+			outOfOrder = outOfOrder || seen["PLTE"] || seen["tRNS"]
+		case "tRNS", "IDAT":
+			outOfOrder = outOfOrder || d.colorType == ctPaletted && !seen["PLTE"]
+		}
+		if outOfOrder {
+			return nil, png.FormatError("chunk out of order")
+		}
+		seen[kind] = true
+		if kind == "IDAT" {
+			d.idat.remaining = length
+			break
+		}
+		// The length comes from the file, so only the few small chunks
+		// needed to decode are read into memory; the rest are checksummed
+		// as they are skipped.
+		limit, ok := chunkLimits[kind]
+		if !ok {
+			if err := d.idat.skip(length); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if length > limit {
+			return nil, png.FormatError(fmt.Sprintf("bad %s length", kind))
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		d.idat.crc.Write(data)
+		if err := d.idat.verifyChecksum(); err != nil {
+			return nil, err
+		}
+		switch kind {
+		case "IHDR":
+			if err := d.parseHeader(data); err != nil {
+				return nil, err
+			}
+		case "PLTE":
+			if d.colorType == ctGray || d.colorType == ctGrayAlpha {
+				return nil, png.FormatError("PLTE, color type mismatch")
+			}
+			if len(data)%3 != 0 {
+				return nil, png.FormatError("bad PLTE length")
+			}
+			for i := 0; i < len(data)/3 && i < 256; i++ {
+				v := data[3*i : 3*i+3]
+				d.palette[i] = [4]uint16{uint16(v[0]) * 0x101, uint16(v[1]) * 0x101, uint16(v[2]) * 0x101, 0xffff}
+			}
+		case "tRNS":
+			if d.colorType == ctPaletted {
+				for i, alpha := range data {
+					if i < 256 {
+						// Premultiply exactly like color.NRGBA.RGBA.
+						p, a := &d.palette[i], uint32(alpha)*0x101
+						p[0] = uint16(uint32(p[0]) * a / 0xffff)
+						p[1] = uint16(uint32(p[1]) * a / 0xffff)
+						p[2] = uint16(uint32(p[2]) * a / 0xffff)
+						p[3] = uint16(a)
+					}
+				}
+			} else {
+				if want := map[int]int{ctGray: 2, ctTrueColor: 6}[d.colorType]; len(data) != want {
+					return nil, png.FormatError("bad tRNS length")
+				}
+				d.transparent = data
+			}
+		}
+	}
+
+	zr, err := zlib.NewReader(d.idat)
+	if err != nil {
+		return nil, err
+	}
+	d.zr = zr
+	rowBytes := (d.width*d.bitsPerPixel() + 7) / 8
+	d.cur = make([]byte, 1+rowBytes)
+	d.prev = make([]byte, 1+rowBytes)
+	return d, nil
+}
+
+// validDepths has a bit set for each bit depth allowed with a colour type.
+var validDepths = map[int]int{
+	ctGray:      1<<1 | 1<<2 | 1<<4 | 1<<8 | 1<<16,
+	ctTrueColor: 1<<8 | 1<<16,
+	ctPaletted:  1<<1 | 1<<2 | 1<<4 | 1<<8,
+	ctGrayAlpha: 1<<8 | 1<<16,
+	ctRGBA:      1<<8 | 1<<16,
+}
+
+func (d *rowDecoder) parseHeader(data []byte) error {
+	if len(data) != 13 {
+		return png.FormatError("bad IHDR length")
+	}
+	d.width = int(binary.BigEndian.Uint32(data[0:4]))
+	d.height = int(binary.BigEndian.Uint32(data[4:8]))
+	d.depth, d.colorType = int(data[8]), int(data[9])
+	if data[12] != 0 {
+		return fmt.Errorf("%w: interlaced PNG", ErrNotTileable)
+	}
+	// Both sides are at most 2^31-1, and a 16-bit buffer of the image must
+	// fit in an int.
+	if d.width <= 0 || d.height <= 0 || d.width > 1<<31-1 || d.height > 1<<31-1 ||
+		uint64(d.width)*uint64(d.height) > uint64(math.MaxInt)/8 {
+		return png.FormatError("invalid dimensions")
+	}
+	depths, ok := validDepths[d.colorType]
+	if !ok {
+		return png.UnsupportedError(fmt.Sprintf("color type %d", d.colorType))
+	}
+	if depths&(1<<d.depth) == 0 {
+		return png.UnsupportedError(fmt.Sprintf("bit depth %d, color type %d", d.depth, d.colorType))
+	}
+	d.bpp = (d.bitsPerPixel() + 7) / 8
+	return nil
+}
+
+// colorModel returns the colour model of the image image/png would decode,
+// for SaveOptions.Preserve.
+func (d *rowDecoder) colorModel() color.Model {
+	wide := d.depth == 16
+	switch {
+	case d.colorType == ctPaletted:
+		palette := make(color.Palette, 256)
+		for i, p := range d.palette {
+			palette[i] = color.RGBA64{p[0], p[1], p[2], p[3]}
+		}
+		return palette
+	case d.colorType == ctGray && d.transparent == nil:
+		if wide {
+			return color.Gray16Model
+		}
+		return color.GrayModel
+	case d.colorType == ctTrueColor && d.transparent == nil:
+		if wide {
+			return color.RGBA64Model
+		}
+		return color.RGBAModel
+	case wide:
+		return color.NRGBA64Model
+	}
+	return color.NRGBAModel
+}
+
+func (d *rowDecoder) bitsPerPixel() int {
+	channels := map[int]int{ctGray: 1, ctTrueColor: 3, ctPaletted: 1, ctGrayAlpha: 2, ctRGBA: 4}[d.colorType]
+	return channels * d.depth
+}
+
+// readRow decodes the next row into dst, width pixels of 8 bytes each.
+func (d *rowDecoder) readRow(dst []byte) error {
+	d.prev, d.cur = d.cur, d.prev
+	if _, err := io.ReadFull(d.zr, d.cur); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if err := unfilter(d.cur, d.prev, d.bpp); err != nil {
+		return err
+	}
+	d.convert(dst, d.cur[1:])
+	return nil
+}
+
+// close checks that the image data ends after the last row, and reads the
+// rest of the file up to IEND, verifying every checksum like image/png.
+// As there, IDAT data after the end of the zlib stream is ignored.
+func (d *rowDecoder) close() error {
+	// Reading past the last row makes zlib verify its own checksum.
+	var extra [1]byte
+	if n, err := io.ReadFull(d.zr, extra[:]); n != 0 {
+		return png.FormatError("too much pixel data")
+	} else if err != io.EOF {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, d.idat); err != nil {
+		return err
+	}
+	kind, length := d.idat.next, d.idat.nextLength
+	for {
+		if err := d.idat.skip(length); err != nil {
+			return err
+		}
+		if kind == "IEND" {
+			return nil
+		}
+		var err error
+		if length, kind, err = d.idat.chunkHeader(); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+}
+
+// unfilter reverses the filter named by cur[0] on the row cur[1:] given the
+// previous (unfiltered) row prev.
+func unfilter(cur, prev []byte, bpp int) error {
+	filter, cdat, pdat := cur[0], cur[1:], prev[1:]
+	switch filter {
+	case 0:
+	case 1:
+		for i := bpp; i < len(cdat); i++ {
+			cdat[i] += cdat[i-bpp]
+		}
+	case 2:
+		for i := range cdat {
+			cdat[i] += pdat[i]
+		}
+	case 3:
+		for i := range cdat {
+			left := 0
+			if i >= bpp {
+				left = int(cdat[i-bpp])
+			}
+			cdat[i] += uint8((left + int(pdat[i])) / 2)
+		}
+	case 4:
+		for i := range cdat {
+			var a, c int
+			if i >= bpp {
+				a, c = int(cdat[i-bpp]), int(pdat[i-bpp])
+			}
+			cdat[i] += uint8(paeth(a, int(pdat[i]), c))
+		}
+	default:
+		return png.FormatError("bad filter type")
+	}
+	return nil
+}
+
+func paeth(a, b, c int) int {
+	p := a + b - c
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// convert turns the unfiltered samples of a row into premultiplied RGBA64,
+// matching the image type image/png decodes to and copyInto's conversion
+// of it.
+func (d *rowDecoder) convert(dst, src []byte) {
+	trns := d.transparent != nil
+	switch {
+	case d.colorType == ctPaletted:
+		for x := 0; x < d.width; x++ {
+			p := d.palette[d.sample(src, x)]
+			setPix(dst, x*8, p[0], p[1], p[2], p[3])
+		}
+	case d.colorType == ctGray && d.depth < 16:
+		// Like image/png, low bit depths are scaled to 8 bits before being
+		// compared with the low byte of tRNS.
+		scale := uint8(0xff / (1<<d.depth - 1))
+		var ty uint8
+		if trns {
+			ty = d.transparent[1] * scale
+		}
+		for x := 0; x < d.width; x++ {
+			v := uint8(d.sample(src, x)) * scale
+			if trns && v == ty {
+				setPix(dst, x*8, 0, 0, 0, 0)
+				continue
+			}
+			setPix(dst, x*8, uint16(v)*0x101, uint16(v)*0x101, uint16(v)*0x101, 0xffff)
+		}
+	case d.colorType == ctGray:
+		for x := 0; x < d.width; x++ {
+			v := binary.BigEndian.Uint16(src[2*x:])
+			if trns && v == binary.BigEndian.Uint16(d.transparent) {
+				setPix(dst, x*8, 0, 0, 0, 0)
+				continue
+			}
+			setPix(dst, x*8, v, v, v, 0xffff)
+		}
+	case d.colorType == ctTrueColor && d.depth == 8:
+		for x := 0; x < d.width; x++ {
+			s := src[3*x : 3*x+3]
+			if trns && s[0] == d.transparent[1] && s[1] == d.transparent[3] && s[2] == d.transparent[5] {
+				setPix(dst, x*8, 0, 0, 0, 0)
+				continue
+			}
+			setPix(dst, x*8, uint16(s[0])*0x101, uint16(s[1])*0x101, uint16(s[2])*0x101, 0xffff)
+		}
+	case d.colorType == ctTrueColor:
+		for x := 0; x < d.width; x++ {
+			s := src[6*x : 6*x+6]
+			if trns && string(s) == string(d.transparent) {
+				setPix(dst, x*8, 0, 0, 0, 0)
+				continue
+			}
+			setPix(dst, x*8, binary.BigEndian.Uint16(s), binary.BigEndian.Uint16(s[2:]), binary.BigEndian.Uint16(s[4:]), 0xffff)
+		}
+	default:
+		// Gray with alpha and RGBA, with straight alpha.
+		channels := d.bpp / (d.depth / 8)
+		for x := 0; x < d.width; x++ {
+			var c [4]uint32
+			for k := 0; k < channels; k++ {
+				if d.depth == 8 {
+					c[k] = uint32(src[x*channels+k]) * 0x101
+				} else {
+					c[k] = uint32(binary.BigEndian.Uint16(src[2*(x*channels+k):]))
+				}
+			}
+			if channels == 2 {
+				c = [4]uint32{c[0], c[0], c[0], c[1]}
+			}
+			a := c[3]
+			setPix(dst, x*8, uint16(c[0]*a/0xffff), uint16(c[1]*a/0xffff), uint16(c[2]*a/0xffff), uint16(a))
+		}
+	}
+}
+
+// sample returns the x-th sample of a row of 1, 2, 4 or 8 bit samples.
+func (d *rowDecoder) sample(src []byte, x int) int {
+	if d.depth == 8 {
+		return int(src[x])
+	}
+	perByte := 8 / d.depth
+	shift := 8 - d.depth*(x%perByte+1)
+	return int(src[x/perByte]>>shift) & (1<<d.depth - 1)
+}
+
+// rowEncoder writes a non-interlaced 8 or 16-bit RGBA PNG a row at a time.
+type rowEncoder struct {
+	w        io.Writer
+	width    int
+	depth    int
+	chunks   *bufio.Writer
+	zw       *zlib.Writer
+	filter   bool
+	cur      []byte
+	prev     []byte
+	filtered [5][]byte
+}
+
+// chunkWriter writes everything written to it as IDAT chunks.
+type chunkWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	cw.err = writeChunk(cw.w, "IDAT", p)
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	return len(p), nil
+}
+
+func writeChunk(w io.Writer, kind string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], kind)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	footer := binary.BigEndian.AppendUint32(nil, crc.Sum32())
+	for _, b := range [][]byte{header, data, footer} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newRowEncoder writes the header of a width x height image with depth bits
+// per channel and compression level level to w.
+func newRowEncoder(w io.Writer, width, height, depth int, level png.CompressionLevel) (*rowEncoder, error) {
+	if _, err := io.WriteString(w, pngHeader); err != nil {
+		return nil, err
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	ihdr[8], ihdr[9] = uint8(depth), ctRGBA
+	if err := writeChunk(w, "IHDR", ihdr); err != nil {
+		return nil, err
+	}
+
+	zlevel := map[png.CompressionLevel]int{
+		png.DefaultCompression: zlib.DefaultCompression,
+		png.NoCompression:      zlib.NoCompression,
+		png.BestSpeed:          zlib.BestSpeed,
+		png.BestCompression:    zlib.BestCompression,
+	}[level]
+	e := &rowEncoder{w: w, width: width, depth: depth, filter: level != png.NoCompression}
+	// Buffering the chunk writer keeps IDAT chunks at a sensible size.
+	e.chunks = bufio.NewWriterSize(&chunkWriter{w: w}, 1<<15)
+	zw, err := zlib.NewWriterLevel(e.chunks, zlevel)
+	if err != nil {
+		return nil, err
+	}
+	e.zw = zw
+	rowBytes := width * 4 * depth / 8
+	e.cur, e.prev = make([]byte, 1+rowBytes), make([]byte, 1+rowBytes)
+	for i := range e.filtered {
+		e.filtered[i] = make([]byte, 1+rowBytes)
+	}
+	return e, nil
+}
+
+// writeRow writes a row of premultiplied RGBA64 pixels, unpremultiplying
+// them like the NRGBA and NRGBA64 colour models do.
+func (e *rowEncoder) writeRow(src []byte) error {
+	cdat := e.cur[1:]
+	for x := 0; x < e.width; x++ {
+		r, g, b, a := getPix(src, x*8)
+		if a == 0 {
+			r, g, b = 0, 0, 0
+		} else if a != 0xffff {
+			r = uint16(uint32(r) * 0xffff / uint32(a))
+			g = uint16(uint32(g) * 0xffff / uint32(a))
+			b = uint16(uint32(b) * 0xffff / uint32(a))
+		}
+		if e.depth == 8 {
+			s := cdat[4*x : 4*x+4]
+			s[0], s[1], s[2], s[3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), uint8(a>>8)
+		} else {
+			setPix(cdat, 8*x, r, g, b, a)
+		}
+	}
+
+	row := e.cur
+	if e.filter {
+		row = e.bestFilter()
+	}
+	_, err := e.zw.Write(row)
+	e.prev, e.cur = e.cur, e.prev
+	return err
+}
+
+// bestFilter filters the current row with each of the five PNG filters and
+// returns the one with the smallest sum of absolute values, the heuristic
+// image/png uses too.
+func (e *rowEncoder) bestFilter() []byte {
+	bpp := e.depth / 2
+	cdat, pdat := e.cur[1:], e.prev[1:]
+	best, bestSum := 0, -1
+	for f := range e.filtered {
+		out := e.filtered[f]
+		out[0] = uint8(f)
+		o := out[1:]
+		for i := range cdat {
+			var a, b, c int
+			if i >= bpp {
+				a, c = int(cdat[i-bpp]), int(pdat[i-bpp])
+			}
+			b = int(pdat[i])
+			var predict int
+			switch f {
+			case 1:
+				predict = a
+			case 2:
+				predict = b
+			case 3:
+				predict = (a + b) / 2
+			case 4:
+				predict = paeth(a, b, c)
+			}
+			o[i] = cdat[i] - uint8(predict)
+		}
+		sum := 0
+		for _, v := range o {
+			sum += abs(int(int8(v)))
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = f, sum
+		}
+	}
+	return e.filtered[best]
+}
+
+// close finishes the image data and writes the end of the file.
+func (e *rowEncoder) close() error {
+	if err := e.zw.Close(); err != nil {
+		return err
+	}
+	if err := e.chunks.Flush(); err != nil {
+		return err
+	}
+	return writeChunk(e.w, "IEND", nil)
+}
+
+// ErrNotTileable is returned by RunTiled for files, effects or save options
+// that can't be handled a strip at a time; Load and RunEffects can process
+// them instead.
+var ErrNotTileable = errors.New("png: can't process in strips")
@@ -0,0 +1,183 @@
+package png
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stripHalo returns how many rows above and below a strip the effects need
+// to compute the strip exactly: one per 3x3 convolution, since each one
+// spreads a pixel's influence a row further. Effects that need more than
+// their neighbourhood (resampling, histograms, overlays) can't be run in
+// strips and return ErrNotTileable.
+func stripHalo(effects []string) (int, error) {
+	halo := 0
+	for _, effect := range effects {
+		spec, err := parseEffect(effect)
+		if err != nil {
+			return 0, err
+		}
+		// Regions stay put in strips, which keep their place in the image.
+		switch spec.name {
+		case "S", "E", "B":
+			halo++
+		case "G", "brightness", "contrast", "gamma", "levels", "curves",
+			"hue", "saturation", "vibrance", "lightness", "grayscale":
+		default:
+			return 0, fmt.Errorf("%w: effect %q", ErrNotTileable, spec.name)
+		}
+	}
+	return halo, nil
+}
+
+// tiling checks that effects and opts can be run in strips and returns the
+// halo the strips need and the bit depth and compression to write.
+func tiling(outPath string, effects []string, opts SaveOptions) (halo, depth int, level png.CompressionLevel, err error) {
+	if ext := strings.ToLower(filepath.Ext(outPath)); ext != ".png" {
+		return 0, 0, 0, fmt.Errorf("%w: %q output", ErrNotTileable, ext)
+	}
+	if opts.Palette || opts.Preserve {
+		return 0, 0, 0, fmt.Errorf("%w: palette and preserve need the whole image", ErrNotTileable)
+	}
+	depth = opts.BitDepth
+	switch depth {
+	case 0:
+		depth = 16
+	case 8, 16:
+	default:
+		return 0, 0, 0, fmt.Errorf("bit depth %d is not 8 or 16", opts.BitDepth)
+	}
+	level, ok := compressionLevels[opts.Compression]
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("unknown PNG compression %q", opts.Compression)
+	}
+	halo, err = stripHalo(effects)
+	return halo, depth, level, err
+}
+
+// Tileable reports whether RunTiled can process inPath into outPath, by
+// checking the effects, the options and the header of inPath. It returns
+// nil if so, and otherwise the error RunTiled would.
+func Tileable(inPath, outPath string, effects []string, opts SaveOptions) error {
+	if _, _, _, err := tiling(outPath, effects, opts); err != nil {
+		return err
+	}
+	inReader, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer inReader.Close()
+	_, err = newRowDecoder(inReader)
+	return err
+}
+
+// RunTiled applies effects to the PNG at inPath and writes the result to the
+// PNG outPath without ever holding the whole image: rows are decoded,
+// processed and encoded stripRows at a time, each strip with enough rows of
+// its neighbours around it that the output is identical to Load, RunEffects
+// and SaveWith. linear, dir and opts play the roles of Image.LinearLight,
+// Image.Dir and the SaveWith options.
+//
+// Only per-pixel and 3x3 convolution effects, 8 or 16-bit output and
+// non-interlaced input are supported; anything else returns ErrNotTileable.
+func RunTiled(inPath, outPath string, effects []string, stripRows int, linear bool, dir string, opts SaveOptions) error {
+	if stripRows <= 0 {
+		return fmt.Errorf("strip rows must be positive, got %d", stripRows)
+	}
+	halo, depth, level, err := tiling(outPath, effects, opts)
+	if err != nil {
+		return err
+	}
+
+	inReader, err := os.Open(inPath)
+	if err != nil {
+		return err
+	}
+	defer inReader.Close()
+	dec, err := newRowDecoder(inReader)
+	if err != nil {
+		return err
+	}
+
+	outWriter, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer outWriter.Close()
+	buffered := bufio.NewWriter(outWriter)
+	enc, err := newRowEncoder(buffered, dec.width, dec.height, depth, level)
+	if err != nil {
+		return err
+	}
+
+	// window holds the decoded rows [top, top+len(window)), recycling the
+	// rows that scroll off the top.
+	width, height := dec.width, dec.height
+	var window, spare [][]byte
+	top := 0
+	for y0 := 0; y0 < height; y0 += stripRows {
+		y1 := y0 + stripRows
+		if y1 > height {
+			y1 = height
+		}
+		lo, hi := y0-halo, y1+halo
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > height {
+			hi = height
+		}
+
+		for top < lo {
+			spare, window = append(spare, window[0]), window[1:]
+			top++
+		}
+		for top+len(window) < hi {
+			var row []byte
+			if n := len(spare); n > 0 {
+				row, spare = spare[n-1], spare[:n-1]
+			} else {
+				row = make([]byte, width*8)
+			}
+			if err := dec.readRow(row); err != nil {
+				return err
+			}
+			window = append(window, row)
+		}
+
+		// The strip keeps its place in the image, so at the top and bottom
+		// its edges are the image's and convolutions clamp to them as they
+		// do in memory. Elsewhere the halo absorbs the wrong edge values.
+		bounds := image.Rect(0, lo, width, hi)
+		strip := &Image{Bounds: bounds, LinearLight: linear, Dir: dir, Format: "png", pool: DefaultPool}
+		strip.in = DefaultPool.Get(bounds)
+		strip.out = DefaultPool.Get(bounds)
+		for k, row := range window {
+			copy(strip.in.Pix[k*strip.in.Stride:], row)
+		}
+		strip.RunEffects(effects)
+		for y := y0; y < y1; y++ {
+			i := strip.out.PixOffset(0, y)
+			if err := enc.writeRow(strip.out.Pix[i : i+width*8]); err != nil {
+				return err
+			}
+		}
+		strip.Release()
+	}
+
+	if err := dec.close(); err != nil {
+		return err
+	}
+	if err := enc.close(); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	return outWriter.Close()
+}
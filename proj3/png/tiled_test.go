@@ -0,0 +1,564 @@
+package png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+// decodeFile loads path the way Load does and returns its pixels.
+func decodeFile(t *testing.T, path string) *image.RGBA64 {
+	t.Helper()
+	img, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img.in
+}
+
+func writePNG(t *testing.T, path string, m image.Image) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTiledMatchesInMemory(t *testing.T) {
+	sources := testSources()
+	tall := image.NewNRGBA64(image.Rect(0, 0, 19, 211))
+	for i := range tall.Pix {
+		tall.Pix[i] = uint8(i*i + i/7)
+	}
+	sources["tall"] = tall
+	opaque := image.NewRGBA64(image.Rect(0, 0, 16, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 16; x++ {
+			opaque.Set(x, y, color.RGBA64{uint16(x * 4000), uint16(y * 1600), uint16(x * y * 97), 0xffff})
+		}
+	}
+	sources["opaque RGBA64"] = opaque
+
+	chains := [][]string{
+		nil,
+		{"B"},
+		{"E"},
+		{"S", "B", "B", "E"},
+		{"G", "B", "contrast:v=0.3", "S"},
+		{"hue:deg=40", "B", "levels:black=0.1,white=0.9"},
+		{"B:roi=2 3 10 12,feather=3", "S", "contrast:v=0.5,roi=0 8 40 4"},
+	}
+	dir := t.TempDir()
+	for name, src := range sources {
+		in := filepath.Join(dir, "in.png")
+		writePNG(t, in, src)
+		for _, effects := range chains {
+			for _, linear := range []bool{false, true} {
+				for _, depth := range []int{0, 8} {
+					opts := SaveOptions{BitDepth: depth}
+					want := filepath.Join(dir, "want.png")
+					img, err := Load(in)
+					if err != nil {
+						t.Fatal(err)
+					}
+					img.LinearLight = linear
+					img.RunEffects(effects)
+					if err := img.SaveWith(want, opts); err != nil {
+						t.Fatal(err)
+					}
+					for _, rows := range []int{1, 5, 64} {
+						got := filepath.Join(dir, "got.png")
+						if err := RunTiled(in, got, effects, rows, linear, "", opts); err != nil {
+							t.Fatalf("%s %v: %v", name, effects, err)
+						}
+						if !equalPix(decodeFile(t, got), decodeFile(t, want)) {
+							t.Errorf("%s %v linear=%v depth=%d in strips of %d differs from in memory",
+								name, effects, linear, depth, rows)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// rawPNG builds a PNG with the given header fields, extra chunks before the
+// image data and unfiltered rows, for the formats image/png doesn't write.
+func rawPNG(width, height, depth, colorType int, chunks map[string][]byte, rows [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(pngHeader)
+	ihdr := []byte{0, 0, 0, byte(width), 0, 0, 0, byte(height), byte(depth), byte(colorType), 0, 0, 0}
+	writeChunk(&buf, "IHDR", ihdr)
+	for _, kind := range []string{"PLTE", "tRNS"} {
+		if data, ok := chunks[kind]; ok {
+			writeChunk(&buf, kind, data)
+		}
+	}
+	var data bytes.Buffer
+	zw := zlib.NewWriter(&data)
+	for _, row := range rows {
+		zw.Write(append([]byte{0}, row...))
+	}
+	zw.Close()
+	writeChunk(&buf, "IDAT", data.Bytes())
+	writeChunk(&buf, "IEND", nil)
+	return buf.Bytes()
+}
+
+func TestRowDecoderMatchesLoad(t *testing.T) {
+	rows := [][]byte{{0x1b, 0xe4, 0x72}, {0xff, 0x00, 0x5a}}
+	files := map[string][]byte{
+		"gray1 tRNS": rawPNG(24, 2, 1, ctGray, map[string][]byte{"tRNS": {0, 1}}, rows),
+		"gray2 tRNS": rawPNG(12, 2, 2, ctGray, map[string][]byte{"tRNS": {0, 2}}, rows),
+		"gray4 tRNS": rawPNG(6, 2, 4, ctGray, map[string][]byte{"tRNS": {0, 0xa}}, rows),
+		"gray8 tRNS": rawPNG(3, 2, 8, ctGray, map[string][]byte{"tRNS": {0, 0x5a}}, rows),
+		"rgb8 tRNS":  rawPNG(1, 2, 8, ctTrueColor, map[string][]byte{"tRNS": {0, 0xff, 0, 0, 0, 0x5a}}, rows),
+		"gray alpha": rawPNG(1, 2, 16, ctGrayAlpha, nil, [][]byte{{1, 2, 0x80, 4}, {0xff, 0xfe, 0, 9}}),
+		"paletted2": rawPNG(12, 2, 2, ctPaletted, map[string][]byte{
+			// Index 3 is out of range and decodes as opaque black.
+			"PLTE": {255, 0, 0, 0, 255, 0, 10, 20, 30},
+			"tRNS": {128, 0},
+		}, rows),
+	}
+	dir := t.TempDir()
+	for name, file := range files {
+		path := filepath.Join(dir, "raw.png")
+		if err := os.WriteFile(path, file, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// Load decodes PNGs with a rowDecoder too, so compare both with
+		// what image/png decodes.
+		decoded, err := png.Decode(bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		want := newImage(decoded).in
+		loaded, err := Load(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !equalPix(loaded.in, want) {
+			t.Errorf("%s: Load gives %v, image/png %v", name, loaded.in.Pix, want.Pix)
+		}
+		if !sameModel(loaded.sourceModel, decoded.ColorModel()) {
+			t.Errorf("%s: Load reports colour model %v, image/png %v", name, loaded.sourceModel, decoded.ColorModel())
+		}
+
+		dec, err := newRowDecoder(bytes.NewReader(file))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got := image.NewRGBA64(image.Rect(0, 0, dec.width, dec.height))
+		for y := 0; y < dec.height; y++ {
+			if err := dec.readRow(got.Pix[y*got.Stride:]); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+		if !equalPix(got, want) {
+			t.Errorf("%s: rows decode to %v, image/png gives %v", name, got.Pix, want.Pix)
+		}
+	}
+}
+
+// sameModel reports whether a and b are the same colour model, counting any
+// two palettes as the same.
+func sameModel(a, b color.Model) bool {
+	_, aPalette := a.(color.Palette)
+	_, bPalette := b.(color.Palette)
+	if aPalette || bPalette {
+		return aPalette && bPalette
+	}
+	return a == b
+}
+
+func TestLoadColorModel(t *testing.T) {
+	dir := t.TempDir()
+	opaque16 := image.NewRGBA64(image.Rect(0, 0, 2, 2))
+	for i := range opaque16.Pix {
+		opaque16.Pix[i] = 0xff
+	}
+	for name, m := range map[string]image.Image{
+		"nrgba":    gradient(),
+		"rgb":      solid(2, 2, color.NRGBA{1, 2, 3, 255}),
+		"nrgba64":  image.NewNRGBA64(image.Rect(0, 0, 2, 2)),
+		"rgb64":    opaque16,
+		"gray":     image.NewGray(image.Rect(0, 0, 2, 2)),
+		"gray16":   image.NewGray16(image.Rect(0, 0, 2, 2)),
+		"paletted": paletted(),
+	} {
+		path := filepath.Join(dir, name+".png")
+		writePNG(t, path, m)
+		img, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, _ := os.Open(path)
+		decoded, err := png.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameModel(img.sourceModel, decoded.ColorModel()) || img.Format != "png" {
+			t.Errorf("%s: Load reports %v (%s), image/png decodes %v", name, img.sourceModel, img.Format, decoded.ColorModel())
+		}
+		if !equalPix(img.in, newImage(decoded).in) {
+			t.Errorf("%s: Load and image/png decode different pixels", name)
+		}
+	}
+}
+
+func TestLoadRejectsWhatImagePNGRejects(t *testing.T) {
+	dir := t.TempDir()
+	rows := [][]byte{{1, 2, 3, 4, 5, 6}, {7, 8, 9, 10, 11, 12}}
+	rgb := rawPNG(2, 2, 8, ctTrueColor, nil, rows)
+	lastCRC := append([]byte(nil), rgb...)
+	lastCRC[len(lastCRC)-12-1] ^= 1
+	for name, file := range map[string][]byte{
+		"too much pixel data": rawPNG(2, 2, 8, ctTrueColor, nil, append(rows, rows[0], rows[1], rows[0])),
+		"truncated data":      rgb[:len(rgb)-12-8],
+		"bad IDAT checksum":   lastCRC,
+		"missing PLTE":        rawPNG(2, 2, 8, ctPaletted, nil, [][]byte{{0, 0}, {0, 0}}),
+		"grey with PLTE":      rawPNG(2, 2, 8, ctGray, map[string][]byte{"PLTE": {1, 2, 3}}, [][]byte{{0, 0}, {0, 0}}),
+	} {
+		if _, err := png.Decode(bytes.NewReader(file)); err == nil {
+			t.Fatalf("%s: image/png decodes it", name)
+		}
+		path := filepath.Join(dir, "in.png")
+		if err := os.WriteFile(path, file, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if img, err := Load(path); err == nil {
+			img.Release()
+			t.Errorf("%s: Load returned no error", name)
+		}
+	}
+	if _, err := newRowDecoder(bytes.NewReader(rawPNG(2, 2, 8, ctTrueColor, nil, rows))); err != nil {
+		t.Errorf("valid file: %v", err)
+	}
+}
+
+func TestRowDecoderRejects(t *testing.T) {
+	rows := [][]byte{{0, 0, 0}}
+	ihdr := func(width, height uint32, depth, colorType byte) []byte {
+		var buf bytes.Buffer
+		buf.WriteString(pngHeader)
+		data := make([]byte, 13)
+		binary.BigEndian.PutUint32(data, width)
+		binary.BigEndian.PutUint32(data[4:], height)
+		data[8], data[9] = depth, colorType
+		writeChunk(&buf, "IHDR", data)
+		return buf.Bytes()
+	}
+	// An ancillary chunk claiming 2 GiB that the file doesn't hold.
+	huge := append(ihdr(1, 1, 8, ctRGBA), 0x7f, 0xff, 0xff, 0xff, 't', 'E', 'X', 't', 'x')
+	files := map[string][]byte{
+		"gray depth 3":      rawPNG(1, 1, 3, ctGray, nil, rows),
+		"gray depth 32":     rawPNG(1, 1, 32, ctGray, nil, rows),
+		"paletted depth 16": rawPNG(1, 1, 16, ctPaletted, map[string][]byte{"PLTE": {1, 2, 3}}, rows),
+		"rgb depth 4":       rawPNG(1, 1, 4, ctTrueColor, nil, rows),
+		"color type 5":      rawPNG(1, 1, 8, 5, nil, rows),
+		"short PLTE":        rawPNG(1, 1, 8, ctPaletted, map[string][]byte{"PLTE": {1, 2}}, rows),
+		"long tRNS":         rawPNG(1, 1, 8, ctPaletted, map[string][]byte{"PLTE": {1, 2, 3}, "tRNS": make([]byte, 257)}, rows),
+		"huge image":        ihdr(1<<31-1, 1<<31-1, 16, ctRGBA),
+		"huge chunk":        huge,
+	}
+	for name, file := range files {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, err := newRowDecoder(bytes.NewReader(file)); err == nil || errors.Is(err, ErrNotTileable) {
+			t.Errorf("%s: got %v, want a decoding error", name, err)
+		}
+		runtime.ReadMemStats(&after)
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("%s: allocated %d bytes", name, allocated)
+		}
+	}
+}
+
+// grayPNG returns a 2x2 8-bit grey PNG whose image data is split over
+// the given IDAT chunks, followed by tail.
+func grayPNG(idats [][]byte, tail []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(pngHeader)
+	writeChunk(&buf, "IHDR", []byte{0, 0, 0, 2, 0, 0, 0, 2, 8, ctGray, 0, 0, 0})
+	for _, idat := range idats {
+		writeChunk(&buf, "IDAT", idat)
+	}
+	buf.Write(tail)
+	return buf.Bytes()
+}
+
+// zlibRows compresses n unfiltered rows of two grey pixels.
+func zlibRows(n int) []byte {
+	var data bytes.Buffer
+	zw := zlib.NewWriter(&data)
+	for i := 0; i < n; i++ {
+		zw.Write([]byte{0, byte(i), 0x80})
+	}
+	zw.Close()
+	return data.Bytes()
+}
+
+func TestRowDecoderClose(t *testing.T) {
+	var iend bytes.Buffer
+	writeChunk(&iend, "IEND", nil)
+	var text bytes.Buffer
+	writeChunk(&text, "tEXt", []byte("a\x00b"))
+	data := zlibRows(2)
+
+	badCRC := func(file []byte, fromEnd int) []byte {
+		file = append([]byte(nil), file...)
+		file[len(file)-fromEnd] ^= 1
+		return file
+	}
+	valid := map[string][]byte{
+		"one IDAT":          grayPNG([][]byte{data}, iend.Bytes()),
+		"split IDAT":        grayPNG([][]byte{data[:3], data[3:7], data[7:]}, iend.Bytes()),
+		"chunk after IDAT":  grayPNG([][]byte{data}, append(text.Bytes(), iend.Bytes()...)),
+		"empty IDAT at end": grayPNG([][]byte{data, nil}, iend.Bytes()),
+		// Like image/png, IDAT data after the zlib stream is ignored.
+		"data after zlib": grayPNG([][]byte{append(data, 0)}, iend.Bytes()),
+		"IDAT after data": grayPNG([][]byte{data, {1, 2}}, iend.Bytes()),
+	}
+	invalid := map[string][]byte{
+		"too many rows":      grayPNG([][]byte{zlibRows(5)}, iend.Bytes()),
+		"bad IDAT checksum":  badCRC(grayPNG([][]byte{data}, iend.Bytes()), 12+1),
+		"bad last checksum":  badCRC(grayPNG([][]byte{data[:5], data[5:]}, iend.Bytes()), 12+1),
+		"bad chunk checksum": badCRC(grayPNG([][]byte{data}, append(text.Bytes(), iend.Bytes()...)), 12+1),
+		"bad IEND checksum":  badCRC(grayPNG([][]byte{data}, iend.Bytes()), 1),
+		"missing IEND":       grayPNG([][]byte{data}, nil),
+		"bad adler checksum": grayPNG([][]byte{badCRC(data, 1)}, iend.Bytes()),
+		"truncated IEND":     grayPNG([][]byte{data}, iend.Bytes()[:6]),
+	}
+	decode := func(file []byte) error {
+		d, err := newRowDecoder(bytes.NewReader(file))
+		if err != nil {
+			return err
+		}
+		dst := make([]byte, 2*8)
+		for y := 0; y < 2; y++ {
+			if err := d.readRow(dst); err != nil {
+				return err
+			}
+		}
+		return d.close()
+	}
+	// Both decoders accept and reject the same files.
+	for name, file := range valid {
+		if err := decode(file); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if _, err := png.Decode(bytes.NewReader(file)); err != nil {
+			t.Errorf("%s: image/png: %v", name, err)
+		}
+	}
+	for name, file := range invalid {
+		if err := decode(file); err == nil {
+			t.Errorf("%s: decoded without error", name)
+		}
+		if _, err := png.Decode(bytes.NewReader(file)); err == nil {
+			t.Errorf("%s: image/png decoded it without error", name)
+		}
+	}
+}
+
+func TestTiledNotTileable(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	writePNG(t, in, gradient())
+	for _, c := range []struct {
+		out     string
+		effects []string
+		opts    SaveOptions
+	}{
+		{"out.png", []string{"B", "resize:w=10"}, SaveOptions{}},
+		{"out.png", []string{"equalize"}, SaveOptions{}},
+		{"out.jpg", []string{"B"}, SaveOptions{}},
+		{"out.png", []string{"B"}, SaveOptions{Palette: true}},
+	} {
+		err := RunTiled(in, filepath.Join(dir, c.out), c.effects, 8, false, "", c.opts)
+		if !errors.Is(err, ErrNotTileable) {
+			t.Errorf("%s %v %+v: got %v, want ErrNotTileable", c.out, c.effects, c.opts, err)
+		}
+	}
+}
+
+func TestMemoryEstimateCoversAllocation(t *testing.T) {
+	saved := DefaultPool
+	defer func() { DefaultPool = saved }()
+	defer debug.SetGCPercent(debug.SetGCPercent(-1))
+
+	dir := t.TempDir()
+	src := image.NewNRGBA(image.Rect(0, 0, 600, 400))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+	writePNG(t, filepath.Join(dir, "in.png"), src)
+	f, err := os.Create(filepath.Join(dir, "in.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(f, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for i, test := range []struct {
+		in      string
+		effects []string
+	}{
+		{"in.png", nil},
+		{"in.jpg", []string{"G", "B"}},
+		{"in.png", []string{"resize:w=1500,filter=lanczos", "S"}},
+		{"in.png", []string{"resize:w=40,h=900,mode=fill", "rotate:deg=30,filter=bicubic", "crop:w=100"}},
+		{"in.png", []string{"perspective:src=0 0 500 20 480 390 10 400", "transpose", "flip"}},
+		{"in.jpg", []string{"equalize", "clahe:tile=8", "levels:black=0.1", "curves:points=0 0 0.5 0.6 1 1"}},
+		{"in.png", []string{"overlay:src=stamp%d.png,anchor=center", "hue:deg=40,mask=stamp%d.png"}},
+	} {
+		// Overlays are cached, so each case loads its own.
+		var effects []string
+		for _, effect := range test.effects {
+			if strings.Contains(effect, "%d") {
+				effect = strings.ReplaceAll(effect, "%d", fmt.Sprint(i))
+				writePNG(t, filepath.Join(dir, fmt.Sprintf("stamp%d.png", i)), src)
+			}
+			effects = append(effects, effect)
+		}
+		DefaultPool = NewBufferPool(0)
+		path := filepath.Join(dir, test.in)
+		estimate, err := MemoryEstimate(path, effects, 0, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		img, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img.Dir = dir
+		img.RunEffects(effects)
+		runtime.ReadMemStats(&after)
+		img.Release()
+
+		if used := int64(after.TotalAlloc - before.TotalAlloc); used > estimate {
+			t.Errorf("%s %v: allocated %d bytes, estimated %d", test.in, effects, used, estimate)
+		} else {
+			t.Logf("%s %v: allocated %d bytes, estimated %d", test.in, effects, used, estimate)
+		}
+	}
+}
+
+func TestMemoryEstimate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "in.png")
+	writePNG(t, path, image.NewRGBA64(image.Rect(0, 0, 10, 20)))
+
+	// The sizes effects turn a 10x20 image into come from the same parsing
+	// they run with.
+	for _, test := range []struct {
+		effects []string
+		size    image.Point
+	}{
+		{[]string{"resize:w=5"}, image.Pt(5, 10)},
+		{[]string{"resize:scale=2"}, image.Pt(20, 40)},
+		{[]string{"resize:scale=2", "crop:w=5,h=5"}, image.Pt(5, 5)},
+		{[]string{"resize:w=30,h=30,mode=fit"}, image.Pt(15, 30)},
+		{[]string{"resize:w=30,h=30,mode=fill"}, image.Pt(30, 30)},
+		{[]string{"rotate:deg=45"}, image.Pt(22, 22)},
+		{[]string{"rotate:deg=-270", "transpose"}, image.Pt(10, 20)},
+		{[]string{"crop:x=8,y=15"}, image.Pt(2, 5)},
+		{[]string{"affine:m=1 0 0 0 1 0,w=50,h=4"}, image.Pt(50, 4)},
+		{[]string{"perspective:src=0 0 40 0 40 30 0 30"}, image.Pt(40, 30)},
+	} {
+		bounds := image.Rect(0, 0, 10, 20)
+		for _, effect := range test.effects {
+			s, err := stepCost(effect, bounds, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bounds = s.bounds
+		}
+		if bounds.Size() != test.size {
+			t.Errorf("%v: size %v, want %v", test.effects, bounds.Size(), test.size)
+		}
+	}
+
+	// Bad entries fail when they run and count for nothing.
+	for _, effect := range []string{"resize:w=-1", "crop:x=10", "rotate:deg=x", "overlay:src=missing.png"} {
+		if s, err := stepCost(effect, image.Rect(0, 0, 10, 20), dir); err == nil {
+			t.Errorf("%s: got %+v, want an error", effect, s)
+		}
+	}
+
+	// Both buffers are rounded up to the pool's bucket size, 1600 to 2048
+	// bytes, and a step that changes the size takes two more.
+	base := int64(codecBytes) + 2*rowBytes(10)
+	n, err := MemoryEstimate(path, nil, 0, dir)
+	if err != nil || n != base+2*2048 {
+		t.Errorf("estimate %d, %v, want %d", n, err, base+2*2048)
+	}
+	n, err = MemoryEstimate(path, []string{"crop:w=5"}, 0, dir)
+	if err != nil || n != base+2*2048+2*1024 {
+		t.Errorf("crop estimate %d, %v, want %d", n, err, base+2*2048+2*1024)
+	}
+
+	// Other formats also hold the decoded image, here one byte per pixel,
+	// and as much again while decoding.
+	jpg := filepath.Join(dir, "in.jpg")
+	f, err := os.Create(jpg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(f, image.NewGray(image.Rect(0, 0, 10, 20)), nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if n, err := MemoryEstimate(jpg, nil, 0, dir); err != nil || n != base+2*10*20+2*2048 {
+		t.Errorf("JPEG estimate %d, %v, want %d", n, err, base+2*10*20+2*2048)
+	}
+
+	n, err = MemoryEstimate(path, []string{"B", "S"}, 4, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A window of 4 rows plus a halo of 2 on either side, its two buffers
+	// of 640 bytes rounded to 1024, the encoder and the offsets of the two
+	// convolutions.
+	want := 2*int64(codecBytes) + 8*rowBytes(10) + 8*10*8 + 2*1024 + 2*8*(10+8+4)
+	if n != want {
+		t.Errorf("strip estimate %d, want %d", n, want)
+	}
+
+	if err := Tileable(path, filepath.Join(dir, "out.png"), []string{"B", "G"}, SaveOptions{}); err != nil {
+		t.Errorf("blur and grayscale not tileable: %v", err)
+	}
+	for _, test := range []struct {
+		out     string
+		effects []string
+	}{
+		{"out.png", []string{"resize:w=5"}},
+		{"out.jpg", []string{"B"}},
+	} {
+		if err := Tileable(path, filepath.Join(dir, test.out), test.effects, SaveOptions{}); !errors.Is(err, ErrNotTileable) {
+			t.Errorf("%s %v: got %v, want ErrNotTileable", test.out, test.effects, err)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package png
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Matrix is a row-major 3x3 homogeneous transform mapping input image
+// coordinates (x, y, 1) to output image coordinates. An affine transform has
+// a last row of 0 0 1.
+type Matrix [9]float64
+
+// Identity is the transform that leaves the image unchanged.
+var Identity = Matrix{1, 0, 0, 0, 1, 0, 0, 0, 1}
+
+// AffineMatrix builds a Matrix from the six coefficients of a 2x3 affine
+// transform [a b c; d e f].
+func AffineMatrix(m [6]float64) Matrix {
+	return Matrix{m[0], m[1], m[2], m[3], m[4], m[5], 0, 0, 1}
+}
+
+// Apply maps the point (x, y) through the transform.
+func (m Matrix) Apply(x, y float64) (float64, float64) {
+	w := m[6]*x + m[7]*y + m[8]
+	return (m[0]*x + m[1]*y + m[2]) / w, (m[3]*x + m[4]*y + m[5]) / w
+}
+
+// Inverse returns the inverse transform, or an error if m is singular.
+func (m Matrix) Inverse() (Matrix, error) {
+	a, b, c := m[0], m[1], m[2]
+	d, e, f := m[3], m[4], m[5]
+	g, h, i := m[6], m[7], m[8]
+	co00, co01, co02 := e*i-f*h, f*g-d*i, d*h-e*g
+	det := a*co00 + b*co01 + c*co02
+	if math.Abs(det) < 1e-12 {
+		return Matrix{}, errors.New("transform matrix is singular")
+	}
+	inv := Matrix{
+		co00, c*h - b*i, b*f - c*e,
+		co01, a*i - c*g, c*d - a*f,
+		co02, b*g - a*h, a*e - b*d,
+	}
+	for k := range inv {
+		inv[k] /= det
+	}
+	return inv, nil
+}
+
+// Point is a position in continuous image coordinates, where pixel (x, y)
+// covers [x, x+1) x [y, y+1).
+type Point struct {
+	X, Y float64
+}
+
+// AffineFromPoints returns the affine transform mapping the three points src
+// onto dst.
+func AffineFromPoints(src, dst [3]Point) (Matrix, error) {
+	// Two independent 3x3 systems, one for each output coordinate.
+	var sys [][]float64
+	for k := 0; k < 3; k++ {
+		sys = append(sys, []float64{src[k].X, src[k].Y, 1, dst[k].X})
+	}
+	row0, err := solve(sys)
+	if err != nil {
+		return Matrix{}, err
+	}
+	sys = sys[:0]
+	for k := 0; k < 3; k++ {
+		sys = append(sys, []float64{src[k].X, src[k].Y, 1, dst[k].Y})
+	}
+	row1, err := solve(sys)
+	if err != nil {
+		return Matrix{}, err
+	}
+	return Matrix{row0[0], row0[1], row0[2], row1[0], row1[1], row1[2], 0, 0, 1}, nil
+}
+
+// HomographyFromPoints returns the perspective transform mapping the four
+// points src onto dst.
+func HomographyFromPoints(src, dst [4]Point) (Matrix, error) {
+	// With h22 fixed to 1 each correspondence gives two linear equations in
+	// the remaining eight coefficients.
+	sys := make([][]float64, 0, 8)
+	for k := 0; k < 4; k++ {
+		x, y, u, v := src[k].X, src[k].Y, dst[k].X, dst[k].Y
+		sys = append(sys,
+			[]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u},
+			[]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v},
+		)
+	}
+	h, err := solve(sys)
+	if err != nil {
+		return Matrix{}, err
+	}
+	return Matrix{h[0], h[1], h[2], h[3], h[4], h[5], h[6], h[7], 1}, nil
+}
+
+// solve solves the n x n linear system given as rows of an augmented matrix
+// using Gaussian elimination with partial pivoting. sys is modified.
+func solve(sys [][]float64) ([]float64, error) {
+	n := len(sys)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(sys[row][col]) > math.Abs(sys[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(sys[pivot][col]) < 1e-12 {
+			return nil, errors.New("control points are degenerate")
+		}
+		sys[col], sys[pivot] = sys[pivot], sys[col]
+		for row := col + 1; row < n; row++ {
+			factor := sys[row][col] / sys[col][col]
+			for k := col; k <= n; k++ {
+				sys[row][k] -= factor * sys[col][k]
+			}
+		}
+	}
+	x := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := sys[row][n]
+		for k := row + 1; k < n; k++ {
+			sum -= sys[row][k] * x[k]
+		}
+		x[row] = sum / sys[row][row]
+	}
+	return x, nil
+}
+
+// Warp transforms the image by m into a new width x height image. Each output
+// pixel is inverse mapped into the input and sampled with filter; pixels that
+// map outside the input are filled with bg.
+func (img *Image) Warp(m Matrix, width, height int, bg color.RGBA64, filter Filter) error {
+	// A homography and its negation are the same transform. Pick the sign
+	// that maps the centre of the input with w > 0, so that w <= 0 below
+	// means behind the horizon and not a flipped matrix.
+	b := img.in.Bounds()
+	cx, cy := float64(b.Min.X+b.Max.X)/2, float64(b.Min.Y+b.Max.Y)/2
+	if m[6]*cx+m[7]*cy+m[8] < 0 {
+		for k := range m {
+			m[k] = -m[k]
+		}
+	}
+	inv, err := m.Inverse()
+	if err != nil {
+		return err
+	}
+	img.warp(width, height, bg, filter, func(x, y float64) (float64, float64) {
+		if w := inv[6]*x + inv[7]*y + inv[8]; w <= 0 {
+			// Behind the horizon of the perspective transform.
+			return math.Inf(-1), math.Inf(-1)
+		}
+		return inv.Apply(x, y)
+	})
+	return nil
+}
+
+// warping is a parsed "affine" or "perspective" entry.
+type warping struct {
+	m             Matrix
+	width, height int
+	bg            color.RGBA64
+	filter        Filter
+}
+
+// parseWarp reads an "affine" or "perspective" entry for an input covering
+// bounds. The transform is given either by its coefficients or by control points, with
+// lists of numbers separated by spaces:
+//
+//	m       6 (affine, a b c d e f) or 9 (perspective) coefficients
+//	src     3 (affine) or 4 (perspective) input points "x0 y0 x1 y1 ..."
+//	dst     matching output points; for perspective it defaults to the
+//	        corners of the output image, which de-skews the src quad
+//	w, h    output size (default: the input size, or the size of the
+//	        de-skewed quad)
+//	bg      fill colour as rrggbb[aa] (default transparent)
+//	filter  nearest, bilinear (default) or bicubic
+func parseWarp(spec effectSpec, bounds image.Rectangle) (warping, error) {
+	var none warping
+	perspective := spec.name == "perspective"
+	corners := 3
+	if perspective {
+		corners = 4
+	}
+	width, err := spec.int("w", 0)
+	if err != nil {
+		return none, err
+	}
+	height, err := spec.int("h", 0)
+	if err != nil {
+		return none, err
+	}
+	bg, err := spec.color("bg", color.RGBA64{})
+	if err != nil {
+		return none, err
+	}
+	filter, err := ParseFilter(spec.str("filter", "bilinear"))
+	if err != nil {
+		return none, err
+	}
+
+	var m Matrix
+	switch {
+	case spec.has("m"):
+		coeffs, err := spec.floats("m")
+		if err != nil {
+			return none, err
+		}
+		want := 6
+		if perspective {
+			want = 9
+		}
+		if len(coeffs) != want {
+			return none, fmt.Errorf("%s: m needs %d coefficients, got %d", spec.name, want, len(coeffs))
+		}
+		if perspective {
+			copy(m[:], coeffs)
+		} else {
+			var affine [6]float64
+			copy(affine[:], coeffs)
+			m = AffineMatrix(affine)
+		}
+	case spec.has("src"):
+		src, err := spec.points("src", corners)
+		if err != nil {
+			return none, err
+		}
+		var dst []Point
+		if spec.has("dst") {
+			if dst, err = spec.points("dst", corners); err != nil {
+				return none, err
+			}
+		} else if perspective {
+			if width == 0 || height == 0 {
+				width, height = quadSize(src)
+			}
+			w, h := float64(width), float64(height)
+			dst = []Point{{0, 0}, {w, 0}, {w, h}, {0, h}}
+		} else {
+			return none, fmt.Errorf("affine: src needs matching dst points")
+		}
+		if perspective {
+			var s4, d4 [4]Point
+			copy(s4[:], src)
+			copy(d4[:], dst)
+			m, err = HomographyFromPoints(s4, d4)
+		} else {
+			var s3, d3 [3]Point
+			copy(s3[:], src)
+			copy(d3[:], dst)
+			m, err = AffineFromPoints(s3, d3)
+		}
+		if err != nil {
+			return none, fmt.Errorf("%s: %v", spec.name, err)
+		}
+	default:
+		return none, fmt.Errorf("%s: needs m or src", spec.name)
+	}
+
+	if width == 0 {
+		width = bounds.Dx()
+	}
+	if height == 0 {
+		height = bounds.Dy()
+	}
+	if width < 0 || height < 0 {
+		return none, fmt.Errorf("%s: w and h must be positive", spec.name)
+	}
+	if err := checkSize(spec.name, float64(width), float64(height)); err != nil {
+		return none, err
+	}
+	return warping{m, width, height, bg, filter}, nil
+}
+
+// runWarp applies an "affine" or "perspective" entry from effects.txt (see
+// parseWarp).
+func (img *Image) runWarp(spec effectSpec) error {
+	w, err := parseWarp(spec, img.in.Bounds())
+	if err != nil {
+		return err
+	}
+	return img.Warp(w.m, w.width, w.height, w.bg, w.filter)
+}
+
+// quadSize estimates the size of the rectangle that the quad q (clockwise
+// from the top left) was photographed from, by averaging opposite edges.
+func quadSize(q []Point) (int, int) {
+	dist := func(a, b Point) float64 { return math.Hypot(a.X-b.X, a.Y-b.Y) }
+	w := (dist(q[0], q[1]) + dist(q[3], q[2])) / 2
+	h := (dist(q[0], q[3]) + dist(q[1], q[2])) / 2
+	// Sides above maxPixels are cut to just past it before converting them,
+	// so that huge quads fail the size check instead of overflowing int.
+	side := func(v float64) int { return int(math.Min(math.Max(1, math.Round(v)), maxPixels+1)) }
+	return side(w), side(h)
+}
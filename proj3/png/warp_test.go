@@ -0,0 +1,113 @@
+package png
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+// mul returns the matrix product a b.
+func mul(a, b Matrix) Matrix {
+	var m Matrix
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i*3+j] += a[i*3+k] * b[k*3+j]
+			}
+		}
+	}
+	return m
+}
+
+func near(a, b Point) bool {
+	return math.Abs(a.X-b.X) < 1e-9 && math.Abs(a.Y-b.Y) < 1e-9
+}
+
+func TestMatrixInverse(t *testing.T) {
+	for _, m := range []Matrix{
+		Identity,
+		AffineMatrix([6]float64{2, 0.5, -3, -1, 1.5, 7}),
+		{0.9, -0.2, 4, 0.1, 1.1, -2, 0.001, -0.002, 1},
+		{-1, 0, 0, 0, -1, 0, 0, 0, -1},
+	} {
+		inv, err := m.Inverse()
+		if err != nil {
+			t.Errorf("%v: %v", m, err)
+			continue
+		}
+		product := mul(m, inv)
+		for k := range product {
+			if math.Abs(product[k]-Identity[k]) > 1e-9 {
+				t.Errorf("%v times its inverse %v = %v, want the identity", m, inv, product)
+				break
+			}
+		}
+	}
+	if _, err := (Matrix{1, 2, 3, 2, 4, 6, 0, 0, 1}).Inverse(); err == nil {
+		t.Errorf("expected an error inverting a singular matrix")
+	}
+}
+
+func TestTransformFromPoints(t *testing.T) {
+	src3 := [3]Point{{0, 0}, {10, 0}, {0, 5}}
+	dst3 := [3]Point{{2, 3}, {12, 5}, {1, 9}}
+	affine, err := AffineFromPoints(src3, dst3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if affine[6] != 0 || affine[7] != 0 || affine[8] != 1 {
+		t.Errorf("affine transform %v has a perspective row", affine)
+	}
+	for k := range src3 {
+		if x, y := affine.Apply(src3[k].X, src3[k].Y); !near(Point{x, y}, dst3[k]) {
+			t.Errorf("affine maps %v to (%g, %g), want %v", src3[k], x, y, dst3[k])
+		}
+	}
+
+	src4 := [4]Point{{10, 12}, {90, 5}, {95, 70}, {3, 60}}
+	dst4 := [4]Point{{0, 0}, {100, 0}, {100, 80}, {0, 80}}
+	homography, err := HomographyFromPoints(src4, dst4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range src4 {
+		if x, y := homography.Apply(src4[k].X, src4[k].Y); !near(Point{x, y}, dst4[k]) {
+			t.Errorf("homography maps %v to (%g, %g), want %v", src4[k], x, y, dst4[k])
+		}
+	}
+
+	collinear := [4]Point{{0, 0}, {1, 1}, {2, 2}, {3, 3}}
+	if _, err := HomographyFromPoints(collinear, dst4); err == nil {
+		t.Errorf("expected an error for collinear control points")
+	}
+}
+
+func TestWarpNegatedMatrix(t *testing.T) {
+	src := numbered(6, 4)
+	want := runOn(src, false, "perspective:m=1 0 0 0 1 0 0 0 1,filter=nearest")
+	for _, effect := range []string{
+		"perspective:m=-1 0 0 0 -1 0 0 0 -1,filter=nearest",
+		"perspective:m=-2 0 0 0 -2 0 0 0 -2,filter=nearest",
+	} {
+		got := runOn(src, false, effect)
+		if !equalPix(got, want) {
+			t.Errorf("%s does not leave the image unchanged", effect)
+		}
+	}
+	if c := want.RGBA64At(5, 3); c != color.RGBA64Model.Convert(src.At(5, 3)) {
+		t.Errorf("identity warp pixel (5, 3) = %v, want %v", c, src.At(5, 3))
+	}
+}
+
+func TestWarpLimit(t *testing.T) {
+	for _, effect := range []string{
+		"affine:m=1 0 0 0 1 0,w=2000000000",
+		"perspective:m=1 0 0 0 1 0 0 0 1,w=100000,h=100000",
+		"perspective:src=0 0 1e300 0 1e300 1e300 0 1e300",
+	} {
+		img := newImage(numbered(6, 4))
+		if err := img.applyEffect(effect); err == nil {
+			t.Errorf("%q: no error", effect)
+		}
+	}
+}